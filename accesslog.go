@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported access log formats.
+const (
+	accessLogCommon   = "clf"      // Apache Common Log Format.
+	accessLogCombined = "combined" // Apache Combined Log Format, CLF with referer and user agent.
+
+	clfTimeLayout = "02/Jan/2006:15:04:05 -0700" // Time format of the CLF request time.
+)
+
+var ErrInvalidAccessLogFormat = errors.New("invalid access log format")
+
+// accessKey is the context key of the access log entry of a request.
+type accessKey struct{}
+
+// accessEntry represents the details of a request only known to the handlers.
+type accessEntry struct {
+	user string // Authenticated user.
+}
+
+// setAccessUser records the user the request was authenticated as in its access log entry.
+func setAccessUser(r *http.Request, user string) {
+	if entry, ok := r.Context().Value(accessKey{}).(*accessEntry); ok {
+		entry.user = user
+	}
+}
+
+// statusRecorder records the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status int   // Response status code.
+	size   int64 // Number of body bytes written.
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += int64(n)
+
+	return n, err //nolint:wrapcheck // the error is the one of the wrapped ResponseWriter
+}
+
+// Unwrap returns the wrapped ResponseWriter for http.ResponseController.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// clfField renders an empty access log field as a dash.
+func clfField(v string) string {
+	if v == "" {
+		return "-"
+	}
+
+	return v
+}
+
+// clfQuote escapes a value written between double quotes.
+func clfQuote(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// accessLine formats the access log line of a request, whose URI is logged as uri.
+func accessLine(format string, r *http.Request, uri, user string, started time.Time, status int, size int64) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.FormatInt(size, 10)
+	}
+
+	line := fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s`,
+		clfField(host), clfField(user), started.Format(clfTimeLayout),
+		clfQuote(r.Method), clfQuote(uri), clfQuote(r.Proto), status, bytes)
+
+	if format == accessLogCombined {
+		line += fmt.Sprintf(` "%s" "%s"`, clfQuote(clfField(r.Referer())), clfQuote(clfField(r.UserAgent())))
+	}
+
+	return line + "\n"
+}
+
+// accessLog wraps the handler to write a line per request to out in the access log format.
+// State names in the request URI are masked with the redactor, if set.
+// The handler is returned unchanged if the format is empty.
+func accessLog(format string, out io.Writer, nr *nameRedactor, next http.Handler) (http.Handler, error) {
+	switch format {
+	case "":
+		return next, nil
+	case accessLogCommon, accessLogCombined:
+	default:
+		return nil, fmt.Errorf("%w %q: allowed formats are %s, %s",
+			ErrInvalidAccessLogFormat, format, accessLogCommon, accessLogCombined)
+	}
+
+	var mu sync.Mutex
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		entry := &accessEntry{}
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessKey{}, entry)))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		line := accessLine(format, r, nr.redactURI(r), entry.user, started, rec.status, rec.size)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		io.WriteString(out, line) //nolint:errcheck // a failed access log write must not fail the request
+	}), nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("content"))
+	})
+	mux.HandleFunc("/admin", requireAdmin("secret", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	testCases := []struct {
+		format string
+		target string
+		auth   string
+		want   string
+	}{
+		{
+			accessLogCommon, "/state?ID=1", "",
+			`^192\.0\.2\.1 - - \[[^]]+\] "GET /state\?ID=1 HTTP/1\.1" 200 7\n$`,
+		},
+		{
+			accessLogCommon, "/missing", "",
+			`^192\.0\.2\.1 - - \[[^]]+\] "GET /missing HTTP/1\.1" 404 19\n$`,
+		},
+		{
+			accessLogCommon, "/admin", "Bearer secret",
+			`^192\.0\.2\.1 - admin \[[^]]+\] "GET /admin HTTP/1\.1" 204 -\n$`,
+		},
+		{
+			accessLogCombined, "/state", "",
+			`^192\.0\.2\.1 - - \[[^]]+\] "GET /state HTTP/1\.1" 200 7 "https://example\.com/" "tool \\"x\\""\n$`,
+		},
+	}
+
+	for _, tc := range testCases {
+		var out bytes.Buffer
+
+		handler, err := accessLog(tc.format, &out, nil, mux)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		req.Header.Set("Referer", "https://example.com/")
+		req.Header.Set("User-Agent", `tool "x"`)
+
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if !regexp.MustCompile(tc.want).MatchString(out.String()) {
+			t.Fatalf("unexpected access log line for %s: %q", tc.target, out.String())
+		}
+	}
+}
+
+func TestAccessLogFormat(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+
+	if handler, err := accessLog("", nil, nil, mux); err != nil || handler != mux {
+		t.Fatalf("handler wrapped without access log: %v", err)
+	}
+
+	if _, err := accessLog("json", nil, nil, mux); !errors.Is(err, ErrInvalidAccessLogFormat) {
+		t.Fatalf("unexpected error: got %v, want %v", err, ErrInvalidAccessLogFormat)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var ErrNotJSON = errors.New("state is not JSON")
+
+// actionHandlers retrieves the handlers of actions on a state by method and action, e.g. "POST touch".
+func (s *Storage) actionHandlers() map[string]stateHandler {
+	handlers := map[string]stateHandler{
+		http.MethodPost + " touch": s.handleTouch,
+		http.MethodPost + " move":  s.handleMove,
+		http.MethodPost + " copy":  s.handleCopy,
+		http.MethodGet + " tags":   s.handleGetTags,
+		http.MethodPut + " tags":   s.handlePutTags,
+	}
+
+	if s.debug {
+		handlers[http.MethodGet+" lock"] = s.handleGetLock
+	}
+
+	return handlers
+}
+
+// handleAction is a root handler for actions on a state, e.g. POST /{name}/touch.
+func (s *Storage) handleAction(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.pathName(w, r)
+	if !ok {
+		return
+	}
+
+	action := r.PathValue("action")
+
+	log.Debug("Request", "method", r.Method, "name", name, "action", action)
+
+	handler := s.actionHandlers()[r.Method+" "+action]
+
+	if handler == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+
+		return
+	}
+
+	if !s.checkSignature(w, r, name) {
+		return
+	}
+
+	if err := s.expireLock(name); err != nil {
+		writeError(w, "failed to expire lock", name, err)
+
+		return
+	}
+
+	handler(w, r, name)
+}
+
+// handleTouch is HTTP handler for POST /{name}/touch.
+// It updates the state modification time without changing its content.
+func (s *Storage) handleTouch(w http.ResponseWriter, _ *http.Request, name string) {
+	locked, err := s.isLocked(name)
+	if err != nil {
+		writeError(w, "failed to check lock", name, err)
+
+		return
+	}
+
+	if locked {
+		log.Warn("state locked", "name", name)
+		http.Error(w, "Locked", http.StatusLocked)
+
+		return
+	}
+
+	exists, err := s.exists(name)
+	if err != nil {
+		writeError(w, "failed to check state", name, err)
+
+		return
+	}
+
+	if !exists {
+		http.Error(w, "Not Found", http.StatusNotFound)
+
+		return
+	}
+
+	now := time.Now()
+
+	if err := os.Chtimes(filepath.Join(s.path, name+stateFileExt), now, now); err != nil {
+		writeError(w, "failed to touch state", name, err)
+
+		return
+	}
+
+	log.Debug("state touched", "name", name)
+}
+
+// targetName retrieves the validated state name of the to query parameter.
+func (s *Storage) targetName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	to := r.URL.Query().Get("to")
+	if to == "" {
+		http.Error(w, "Bad Request: missing to", http.StatusBadRequest)
+
+		return "", false
+	}
+
+	if err := s.validateName(to); err != nil {
+		log.Warn("invalid target name", "method", r.Method, "error", err)
+		http.Error(w, "Bad Request: "+nameErrorText("to", err), http.StatusBadRequest)
+
+		return "", false
+	}
+
+	return to, true
+}
+
+// checkUnlocked responds with 423 if any of the states is locked.
+// Returns true if none is.
+func (s *Storage) checkUnlocked(w http.ResponseWriter, names ...string) bool {
+	for _, name := range names {
+		locked, err := s.isLocked(name)
+		if err != nil {
+			writeError(w, "failed to check lock", name, err)
+
+			return false
+		}
+
+		if locked {
+			writeError(w, "state locked", name, ErrAlreadyLocked)
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// handleMove is HTTP handler for POST /{name}/move?to=<name>.
+// It renames the state, refusing to replace an existing one or to move a locked one.
+func (s *Storage) handleMove(w http.ResponseWriter, r *http.Request, name string) {
+	to, ok := s.targetName(w, r)
+	if !ok || !s.checkUnlocked(w, name, to) {
+		return
+	}
+
+	exists, err := s.exists(name)
+	if err != nil {
+		writeError(w, "failed to check state", name, err)
+
+		return
+	}
+
+	if !exists {
+		http.Error(w, "Not Found", http.StatusNotFound)
+
+		return
+	}
+
+	src := filepath.Join(s.path, name+stateFileExt)
+	dst := filepath.Join(s.path, to+stateFileExt)
+
+	// Linking fails if the destination exists, unlike renaming which silently replaces it.
+	if err := os.Link(src, dst); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			err = fmt.Errorf("%w: %w: %s", ErrConflict, ErrAlreadyExists, to)
+		}
+
+		writeError(w, "failed to move state", name, err)
+
+		return
+	}
+
+	// The state moved away must not be read from the fallback storage under its old name.
+	err = s.buryState(name)
+	if err == nil {
+		err = os.Remove(src)
+	}
+
+	if err != nil {
+		os.Remove(dst)
+		writeError(w, "failed to move state", name, err)
+
+		return
+	}
+
+	// Tags follow the state, a failure to move them doesn't undo the move.
+	err = os.Rename(filepath.Join(s.path, name+tagsFileExt), filepath.Join(s.path, to+tagsFileExt))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn("failed to move tags", "name", name, "to", to, "error", err)
+	}
+
+	audit(r, "state moved", "name", name, "to", to)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// newLineage generates a random lineage in the UUID format Terraform uses.
+func newLineage() (string, error) {
+	var b [16]byte
+
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate lineage: %w", err)
+	}
+
+	b[6] = b[6]&0x0f | 0x40 //nolint:mnd // UUID version 4
+	b[8] = b[8]&0x3f | 0x80 //nolint:mnd // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// setLineage returns the state with its lineage replaced.
+func setLineage(data []byte, lineage string) ([]byte, error) {
+	var state map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotJSON, err)
+	}
+
+	value, err := json.Marshal(lineage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode lineage: %w", err)
+	}
+
+	state["lineage"] = value
+
+	data, err = json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	return data, nil
+}
+
+// handleCopy is HTTP handler for POST /{name}/copy?to=<name>.
+// It duplicates the state, refusing to replace an existing one or a locked one.
+// The source may be locked as it's only read.
+// With new-lineage=true the copy gets a fresh lineage, so both states aren't taken for the same one.
+func (s *Storage) handleCopy(w http.ResponseWriter, r *http.Request, name string) {
+	to, ok := s.targetName(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+
+	renew, err := strconv.ParseBool(query.Get("new-lineage"))
+	if err != nil && query.Has("new-lineage") {
+		http.Error(w, "Bad Request: invalid new-lineage", http.StatusBadRequest)
+
+		return
+	}
+
+	if !s.checkUnlocked(w, to) {
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.path, name+stateFileExt))
+	if err != nil {
+		writeError(w, "failed to read state", name, err)
+
+		return
+	}
+
+	lineage := ""
+
+	if renew {
+		if lineage, err = newLineage(); err == nil {
+			data, err = setLineage(data, lineage)
+		}
+
+		if errors.Is(err, ErrNotJSON) {
+			log.Warn("lineage of non-JSON state", "name", name, "error", err)
+			http.Error(w, "Unprocessable Entity: state is not JSON", http.StatusUnprocessableEntity)
+
+			return
+		}
+
+		if err != nil {
+			writeError(w, "failed to set lineage", name, err)
+
+			return
+		}
+	}
+
+	if err := s.createFile(filepath.Join(s.path, to+stateFileExt), data); err != nil {
+		if errors.Is(err, ErrAlreadyExists) {
+			err = fmt.Errorf("%w: %w", ErrConflict, err)
+		}
+
+		writeError(w, "failed to copy state", name, err)
+
+		return
+	}
+
+	audit(r, "state copied", "name", name, "to", to, "lineage", lineage)
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func doAction(t *testing.T, storage *Storage, method, state, action string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, "/"+state+"/"+action, nil)
+	req.SetPathValue("name", state)
+	req.SetPathValue("action", action)
+
+	w := httptest.NewRecorder()
+
+	storage.handleAction(w, req)
+
+	return w
+}
+
+func doTargetAction(t *testing.T, storage *Storage, action, state, to string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/"+state+"/"+action+"?to="+url.QueryEscape(to), nil)
+	req.SetPathValue("name", state)
+	req.SetPathValue("action", action)
+
+	w := httptest.NewRecorder()
+
+	storage.handleAction(w, req)
+
+	return w
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), defaultFileMode); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+}
+
+func TestStorageHandleTouch(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	if w := doAction(t, storage, http.MethodPost, name, "touch"); w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code for missing state: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	filePath := filepath.Join(storage.path, name+stateFileExt)
+	old := time.Now().Add(-time.Hour)
+
+	if err := os.WriteFile(filePath, []byte("content"), defaultFileMode); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	if err := os.Chtimes(filePath, old, old); err != nil {
+		t.Fatalf("failed to change file times: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(storage.path, name+lockFileExt), nil, defaultFileMode); err != nil {
+		t.Fatalf("failed to write lock file: %v", err)
+	}
+
+	if w := doAction(t, storage, http.MethodPost, name, "touch"); w.Code != http.StatusLocked {
+		t.Fatalf("unexpected status code for locked state: got %d, want %d", w.Code, http.StatusLocked)
+	}
+
+	if err := os.Remove(filepath.Join(storage.path, name+lockFileExt)); err != nil {
+		t.Fatalf("failed to remove lock file: %v", err)
+	}
+
+	if w := doAction(t, storage, http.MethodPost, name, "touch"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("failed to stat state file: %v", err)
+	}
+
+	if !info.ModTime().After(old) {
+		t.Fatalf("modification time not updated: %s", info.ModTime())
+	}
+
+	if w := doAction(t, storage, http.MethodGet, name, "touch"); w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code for unknown action: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestStorageHandleMove(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	statePath := func(state string) string { return filepath.Join(storage.path, state+stateFileExt) }
+
+	writeTestFile(t, statePath(name), "content")
+	writeTestFile(t, statePath("taken"), "other")
+	writeTestFile(t, filepath.Join(storage.path, "locked"+lockFileExt), "")
+
+	testCases := []struct {
+		state string
+		to    string
+		want  int
+	}{
+		{name, "", http.StatusBadRequest},
+		{name, "../escape", http.StatusBadRequest},
+		{name, "taken", http.StatusConflict},
+		{name, "locked", http.StatusLocked},
+		{"locked", "free", http.StatusLocked},
+		{"missing", "free", http.StatusNotFound},
+		{name, "moved", http.StatusCreated},
+	}
+
+	for _, tc := range testCases {
+		if w := doTargetAction(t, storage, "move", tc.state, tc.to); w.Code != tc.want {
+			t.Fatalf("unexpected status code for %s to %q: got %d, want %d", tc.state, tc.to, w.Code, tc.want)
+		}
+	}
+
+	if _, err := os.Stat(statePath(name)); !os.IsNotExist(err) {
+		t.Fatalf("source state not removed: %v", err)
+	}
+
+	data, err := os.ReadFile(statePath("moved"))
+	if err != nil || string(data) != "content" {
+		t.Fatalf("unexpected moved state: %q, error %v", data, err)
+	}
+
+	if data, err := os.ReadFile(statePath("taken")); err != nil || string(data) != "other" {
+		t.Fatalf("existing state changed: %q, error %v", data, err)
+	}
+}
+
+func TestStorageHandleCopy(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	statePath := func(state string) string { return filepath.Join(storage.path, state+stateFileExt) }
+	content := `{"version":4,"serial":3,"lineage":"prod"}`
+
+	writeTestFile(t, statePath(name), content)
+	writeTestFile(t, statePath("taken"), "other")
+	writeTestFile(t, filepath.Join(storage.path, name+lockFileExt), "")
+	writeTestFile(t, filepath.Join(storage.path, "locked"+lockFileExt), "")
+
+	testCases := []struct {
+		state string
+		to    string
+		want  int
+	}{
+		{name, "", http.StatusBadRequest},
+		{name, "../escape", http.StatusBadRequest},
+		{name, "taken", http.StatusConflict},
+		{name, "locked", http.StatusLocked},
+		{"missing", "free", http.StatusNotFound},
+		{name, "copy", http.StatusCreated},
+	}
+
+	for _, tc := range testCases {
+		if w := doTargetAction(t, storage, "copy", tc.state, tc.to); w.Code != tc.want {
+			t.Fatalf("unexpected status code for %s to %q: got %d, want %d", tc.state, tc.to, w.Code, tc.want)
+		}
+	}
+
+	for _, state := range []string{name, "copy"} {
+		if data, err := os.ReadFile(statePath(state)); err != nil || string(data) != content {
+			t.Fatalf("unexpected content of %s: %q, error %v", state, data, err)
+		}
+	}
+
+	if data, err := os.ReadFile(statePath("taken")); err != nil || string(data) != "other" {
+		t.Fatalf("existing state changed: %q, error %v", data, err)
+	}
+}
+
+func TestStorageHandleCopyNewLineage(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	writeTestFile(t, filepath.Join(storage.path, name+stateFileExt), `{"version":4,"lineage":"prod"}`)
+	writeTestFile(t, filepath.Join(storage.path, "plain"+stateFileExt), "content")
+
+	req := httptest.NewRequest(http.MethodPost, "/"+name+"/copy?to=staging&new-lineage=true", nil)
+	req.SetPathValue("name", name)
+	req.SetPathValue("action", "copy")
+
+	w := httptest.NewRecorder()
+	storage.handleAction(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusCreated)
+	}
+
+	data, err := os.ReadFile(filepath.Join(storage.path, "staging"+stateFileExt))
+	if err != nil {
+		t.Fatalf("failed to read copy: %v", err)
+	}
+
+	var state struct {
+		Version int    `json:"version"`
+		Lineage string `json:"lineage"`
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("failed to decode copy: %v", err)
+	}
+
+	if state.Version != 4 || state.Lineage == "prod" || len(state.Lineage) != len("00000000-0000-4000-8000-000000000000") {
+		t.Fatalf("unexpected copy: %s", data)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/plain/copy?to=other&new-lineage=true", nil)
+	req.SetPathValue("name", "plain")
+	req.SetPathValue("action", "copy")
+
+	w = httptest.NewRecorder()
+	storage.handleAction(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected status code for non-JSON state: got %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	log "log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const gcPauseHistory = 256 // Size of the circular buffer of recent GC pauses in runtime.MemStats.
+
+// requireAdmin wraps the handler so it's only served to requests with the admin bearer token.
+func requireAdmin(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			log.Warn("unauthorized admin request", "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		setAccessUser(r, "admin")
+		next(w, r)
+	}
+}
+
+// audit logs an operator action performed through the request.
+func audit(r *http.Request, msg string, args ...any) {
+	log.Info(msg, append([]any{"audit", true, "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr}, args...)...)
+}
+
+// RuntimeStats represents a snapshot of the process runtime statistics.
+type RuntimeStats struct {
+	Status         string  `json:"status"`
+	Goroutines     int     `json:"goroutines"`
+	HeapAllocBytes uint64  `json:"heapAllocBytes"`
+	HeapObjects    uint64  `json:"heapObjects"`
+	SysBytes       uint64  `json:"sysBytes"`
+	NumGC          uint32  `json:"numGC"`
+	PauseTotalNs   uint64  `json:"pauseTotalNs"`
+	LastPauseNs    uint64  `json:"lastPauseNs"`
+	UptimeSeconds  float64 `json:"uptimeSeconds"`
+}
+
+// handleRuntime retrieves an HTTP handler reporting goroutine, memory and GC statistics.
+func handleRuntime(started time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		var mem runtime.MemStats
+
+		runtime.ReadMemStats(&mem)
+
+		stats := RuntimeStats{
+			Status:         "ok",
+			Goroutines:     runtime.NumGoroutine(),
+			HeapAllocBytes: mem.HeapAlloc,
+			HeapObjects:    mem.HeapObjects,
+			SysBytes:       mem.Sys,
+			NumGC:          mem.NumGC,
+			PauseTotalNs:   mem.PauseTotalNs,
+			LastPauseNs:    mem.PauseNs[(mem.NumGC+gcPauseHistory-1)%gcPauseHistory],
+			UptimeSeconds:  time.Since(started).Seconds(),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			log.Error("failed to encode JSON:", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// handlePurgeLocks is HTTP handler for POST /admin/locks/purge.
+// It removes locks older than the older-than duration and responds with the cleared locks.
+// With dry-run only the locks that would be cleared are listed.
+func (s *Storage) handlePurgeLocks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	olderThan, err := time.ParseDuration(query.Get("older-than"))
+	if err != nil || olderThan <= 0 {
+		http.Error(w, "Bad Request: invalid older-than", http.StatusBadRequest)
+
+		return
+	}
+
+	dryRun, err := strconv.ParseBool(query.Get("dry-run"))
+	if err != nil && query.Has("dry-run") {
+		http.Error(w, "Bad Request: invalid dry-run", http.StatusBadRequest)
+
+		return
+	}
+
+	locks, err := s.listLocks()
+	if err != nil {
+		log.Error("failed to list locks:", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+
+		return
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	locks = slices.DeleteFunc(locks, func(lock Lock) bool { return !lock.LockedAt.Before(cutoff) })
+	cleared := s.clearLocks(r, "lock purged", locks, dryRun)
+
+	type Result struct {
+		Status string `json:"status"`
+		DryRun bool   `json:"dryRun"`
+		Locks  []Lock `json:"locks"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(Result{Status: "ok", DryRun: dryRun, Locks: cleared}); err != nil {
+		log.Error("failed to encode JSON:", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// clearLocks removes the lock files of the locks, audit-logging each one.
+// With dryRun nothing is removed. Returns the locks cleared.
+func (s *Storage) clearLocks(r *http.Request, msg string, locks []Lock, dryRun bool) []Lock {
+	cleared := []Lock{}
+
+	for _, lock := range locks {
+		if !dryRun {
+			err := os.Remove(filepath.Join(s.path, lock.Name+lockFileExt))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Error("failed to remove lock file", "name", lock.Name, "error", err)
+
+				continue
+			}
+
+			s.forgetLock(lock.Name)
+		}
+
+		audit(r, msg, "name", lock.Name, "id", lock.ID, "who", lock.Who, "lockedAt", lock.LockedAt, "dryRun", dryRun)
+
+		cleared = append(cleared, lock)
+	}
+
+	return cleared
+}
+
+// handleUnlockAll retrieves an HTTP handler for POST /admin/unlock-all.
+// It removes every lock and responds with the cleared locks.
+// The confirm query parameter must match the configured token, the request is rejected with 400 otherwise.
+func (s *Storage) handleUnlockAll(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		confirm := r.URL.Query().Get("confirm")
+		if subtle.ConstantTimeCompare([]byte(confirm), []byte(token)) != 1 {
+			log.Warn("unlock-all not confirmed", "remote", r.RemoteAddr)
+			http.Error(w, "Bad Request: invalid confirm", http.StatusBadRequest)
+
+			return
+		}
+
+		locks, err := s.listLocks()
+		if err != nil {
+			log.Error("failed to list locks:", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+
+			return
+		}
+
+		cleared := s.clearLocks(r, "lock cleared by unlock-all", locks, false)
+
+		type Result struct {
+			Status string `json:"status"`
+			Locks  []Lock `json:"locks"`
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(Result{Status: "ok", Locks: cleared}); err != nil {
+			log.Error("failed to encode JSON:", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	t.Parallel()
+
+	handler := requireAdmin("secret", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for auth, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusNoContent,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/runtime", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		if w.Code != want {
+			t.Fatalf("unexpected status code for %q: got %d, want %d", auth, w.Code, want)
+		}
+	}
+}
+
+func TestHandleRuntime(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+
+	handleRuntime(time.Now().Add(-time.Minute))(w, httptest.NewRequest(http.MethodGet, "/admin/runtime", nil))
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	var stats RuntimeStats
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if stats.Goroutines < 1 || stats.HeapAllocBytes == 0 || stats.UptimeSeconds < 60 {
+		t.Fatalf("unexpected runtime stats: %+v", stats)
+	}
+}
+
+func TestStorageHandlePurgeLocks(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	old := time.Now().Add(-2 * time.Hour)
+
+	for state, info := range map[string]string{"old": `{"ID":"1","Who":"ci@runner"}`, "young": `{"ID":"2"}`} {
+		if err := os.WriteFile(filepath.Join(storage.path, state+lockFileExt), []byte(info), defaultFileMode); err != nil {
+			t.Fatalf("failed to write lock file: %v", err)
+		}
+	}
+
+	if err := os.Chtimes(filepath.Join(storage.path, "old"+lockFileExt), old, old); err != nil {
+		t.Fatalf("failed to change file times: %v", err)
+	}
+
+	purge := func(target string) []Lock {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+
+		storage.handlePurgeLocks(w, httptest.NewRequest(http.MethodPost, target, nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", target, w.Code, http.StatusOK)
+		}
+
+		var result struct {
+			Locks []Lock `json:"locks"`
+		}
+
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+
+		return result.Locks
+	}
+
+	for _, target := range []string{"/admin/locks/purge?older-than=1h&dry-run=true", "/admin/locks/purge?older-than=1h"} {
+		locks := purge(target)
+		if len(locks) != 1 || locks[0].Name != "old" || locks[0].Who != "ci@runner" {
+			t.Fatalf("unexpected cleared locks for %s: %+v", target, locks)
+		}
+	}
+
+	for state, want := range map[string]bool{"old": false, "young": true} {
+		if locked, _ := storage.isLocked(state); locked != want {
+			t.Fatalf("unexpected lock state of %s: got %v, want %v", state, locked, want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+
+	storage.handlePurgeLocks(w, httptest.NewRequest(http.MethodPost, "/admin/locks/purge", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code without older-than: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestStorageHandleUnlockAll(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	handler := storage.handleUnlockAll("confirm-me")
+
+	for _, state := range []string{"one", "two"} {
+		if err := os.WriteFile(filepath.Join(storage.path, state+lockFileExt), nil, defaultFileMode); err != nil {
+			t.Fatalf("failed to write lock file: %v", err)
+		}
+	}
+
+	for _, target := range []string{"/admin/unlock-all", "/admin/unlock-all?confirm=wrong"} {
+		w := httptest.NewRecorder()
+
+		handler(w, httptest.NewRequest(http.MethodPost, target, nil))
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	if locks, err := storage.listLocks(); err != nil || len(locks) != 2 {
+		t.Fatalf("locks cleared without confirmation: %+v, error %v", locks, err)
+	}
+
+	w := httptest.NewRecorder()
+
+	handler(w, httptest.NewRequest(http.MethodPost, "/admin/unlock-all?confirm=confirm-me", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var result struct {
+		Locks []Lock `json:"locks"`
+	}
+
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if len(result.Locks) != 2 {
+		t.Fatalf("unexpected cleared locks: %+v", result.Locks)
+	}
+
+	if locks, err := storage.listLocks(); err != nil || len(locks) != 0 {
+		t.Fatalf("locks left after unlock-all: %+v, error %v", locks, err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Content codings GET responses can be compressed with.
+const (
+	encodingGzip     = "gzip"
+	encodingDeflate  = "deflate"
+	encodingIdentity = "identity" // No compression, always acceptable unless refused explicitly.
+
+	defaultCompressionAlgos = encodingGzip + "," + encodingDeflate // Default server preference order.
+)
+
+var ErrUnsupportedCompression = errors.New("unsupported compression algorithm")
+
+// parseCompressionAlgos parses the comma separated server preference order of content codings.
+// Identity is dropped as the uncompressed response is the fallback anyway,
+// so "identity" alone disables compression.
+func parseCompressionAlgos(value string) ([]string, error) {
+	var algos []string
+
+	for _, v := range strings.Split(value, ",") {
+		switch v = strings.ToLower(strings.TrimSpace(v)); v {
+		case "", encodingIdentity:
+			continue
+		case encodingGzip, encodingDeflate:
+			if !slices.Contains(algos, v) {
+				algos = append(algos, v)
+			}
+		default:
+			return nil, fmt.Errorf("%w %q: allowed algorithms are %s, %s, %s",
+				ErrUnsupportedCompression, v, encodingGzip, encodingDeflate, encodingIdentity)
+		}
+	}
+
+	return algos, nil
+}
+
+// parseAcceptEncoding retrieves the quality values of the codings listed in the Accept-Encoding header.
+// Codings with a malformed quality value are ignored.
+func parseAcceptEncoding(header string) map[string]float64 {
+	accepted := make(map[string]float64)
+
+	for _, v := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(v, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+
+		if qv, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(qv, 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				continue
+			}
+
+			q = parsed
+		}
+
+		accepted[coding] = q
+	}
+
+	return accepted
+}
+
+// negotiateEncoding picks the content coding of the response among the server preference order.
+// The coding the client prefers most wins, ties are broken by the server preference order.
+// It returns an empty string if no compression is acceptable.
+func negotiateEncoding(header string, algos []string) string {
+	accepted := parseAcceptEncoding(header)
+	best, bestQ := "", 0.0
+
+	for _, algo := range algos {
+		q, ok := accepted[algo]
+		if !ok {
+			q = accepted["*"]
+		}
+
+		if q > bestQ {
+			best, bestQ = algo, q
+		}
+	}
+
+	return best
+}
+
+// compressWriter retrieves a writer compressing to w with the content coding.
+// The HTTP deflate coding is the zlib format (RFC 9110, section 8.4.1.2), not raw DEFLATE.
+func compressWriter(w io.Writer, encoding string) (io.WriteCloser, error) {
+	if encoding == encodingDeflate {
+		zw, err := zlib.NewWriterLevel(w, zlib.DefaultCompression)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create deflate writer: %w", err)
+		}
+
+		return zw, nil
+	}
+
+	return gzip.NewWriter(w), nil
+}
+
+// encodedETag returns the entity tag of the representation with the content coding.
+// Each coding is a different representation, so it gets its own strong tag.
+func encodedETag(tag, encoding string) string {
+	if encoding == "" {
+		return tag
+	}
+
+	return strings.TrimSuffix(tag, `"`) + "-" + encoding + `"`
+}
+
+// writeCompressed writes data compressed with the coding negotiated for the request.
+// Data is written as is if compression is disabled or not acceptable to the client.
+// An ETag set for the data is made specific to the coding.
+func (s *Storage) writeCompressed(w http.ResponseWriter, r *http.Request, data []byte) error {
+	encoding := ""
+
+	if len(s.compression) > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"), s.compression)
+	}
+
+	if encoding == "" {
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("failed to write response: %w", err)
+		}
+
+		return nil
+	}
+
+	w.Header().Set("Content-Encoding", encoding)
+
+	if tag := w.Header().Get("ETag"); tag != "" {
+		w.Header().Set("ETag", encodedETag(tag, encoding))
+	}
+
+	cw, err := compressWriter(w, encoding)
+	if err != nil {
+		return err
+	}
+
+	if _, err := cw.Write(data); err != nil {
+		cw.Close()
+
+		return fmt.Errorf("failed to compress response: %w", err)
+	}
+
+	if err := cw.Close(); err != nil {
+		return fmt.Errorf("failed to compress response: %w", err)
+	}
+
+	return nil
+}
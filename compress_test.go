@@ -0,0 +1,141 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseCompressionAlgos(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		value string
+		want  []string
+		err   error
+	}{
+		{"gzip,deflate", []string{encodingGzip, encodingDeflate}, nil},
+		{" Deflate , gzip,deflate", []string{encodingDeflate, encodingGzip}, nil},
+		{"identity", nil, nil},
+		{"", nil, nil},
+		{"gzip,zstd", nil, ErrUnsupportedCompression},
+		{"br", nil, ErrUnsupportedCompression},
+	}
+
+	for _, tc := range testCases {
+		got, err := parseCompressionAlgos(tc.value)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("unexpected error for %q: got %v, want %v", tc.value, err, tc.err)
+		}
+
+		if !slices.Equal(got, tc.want) {
+			t.Fatalf("unexpected algorithms for %q: got %v, want %v", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	t.Parallel()
+
+	both := []string{encodingGzip, encodingDeflate}
+
+	testCases := []struct {
+		header string
+		algos  []string
+		want   string
+	}{
+		{"gzip, deflate", both, encodingGzip},
+		{"gzip, deflate", []string{encodingDeflate, encodingGzip}, encodingDeflate},
+		{"deflate", both, encodingDeflate},
+		{"gzip;q=0.5, deflate", both, encodingDeflate},
+		{"GZIP", both, encodingGzip},
+		{"*", both, encodingGzip},
+		{"*;q=0.1, gzip;q=0", both, encodingDeflate},
+		{"gzip;q=0, deflate;q=0", both, ""},
+		{"br, zstd", both, ""},
+		{"identity", both, ""},
+		{"", both, ""},
+		{"gzip, deflate", []string{encodingDeflate}, encodingDeflate},
+		{"gzip", nil, ""},
+		{"gzip;q=2, deflate;q=0.3", both, encodingDeflate},
+	}
+
+	for _, tc := range testCases {
+		if got := negotiateEncoding(tc.header, tc.algos); got != tc.want {
+			t.Fatalf("unexpected encoding for %q with %v: got %q, want %q", tc.header, tc.algos, got, tc.want)
+		}
+	}
+}
+
+func TestStorageHandleGetCompressed(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.compression = []string{encodingGzip, encodingDeflate}
+
+	content := `{"version":4}`
+	if err := os.WriteFile(filepath.Join(storage.path, name+stateFileExt), []byte(content), defaultFileMode); err != nil {
+		t.Fatalf("failed to write state: %v", err)
+	}
+
+	testCases := []struct {
+		accept string
+		want   string
+		reader func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip", encodingGzip, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"deflate", encodingDeflate, func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }},
+		{"br", "", func(r io.Reader) (io.Reader, error) { return r, nil }},
+	}
+
+	tags := map[string]bool{}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/"+name, nil)
+		req.Header.Set("Accept-Encoding", tc.accept)
+
+		w := httptest.NewRecorder()
+		storage.handleGet(w, req, name)
+
+		if got := w.Header().Get("Content-Encoding"); got != tc.want {
+			t.Fatalf("unexpected Content-Encoding for %s: got %q, want %q", tc.accept, got, tc.want)
+		}
+
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Fatalf("unexpected Vary for %s: %q", tc.accept, got)
+		}
+
+		tag := w.Header().Get("ETag")
+		if tag != encodedETag(etag([]byte(content)), tc.want) || tags[tag] {
+			t.Fatalf("unexpected ETag for %s: %s", tc.accept, tag)
+		}
+
+		tags[tag] = true
+
+		match, err := storage.checkIfMatch(&http.Request{Header: http.Header{"If-Match": {tag}}}, name)
+		if err != nil || !match {
+			t.Fatalf("ETag for %s does not satisfy If-Match: %v", tc.accept, err)
+		}
+
+		r, err := tc.reader(w.Body)
+		if err != nil {
+			t.Fatalf("failed to decompress response for %s: %v", tc.accept, err)
+		}
+
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("failed to read response for %s: %v", tc.accept, err)
+		}
+
+		if string(data) != content {
+			t.Fatalf("unexpected response body for %s: got %q, want %q", tc.accept, data, content)
+		}
+	}
+}
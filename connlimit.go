@@ -0,0 +1,133 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	log "log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+const topConnIPs = 10 // Number of source IPs with the most connections exposed as metrics.
+
+// connLimiter caps the number of concurrent connections per source IP.
+type connLimiter struct {
+	max int // Maximum number of concurrent connections per source IP.
+
+	mu       sync.Mutex
+	conns    map[string]int // Open connections by source IP.
+	rejected uint64         // Number of connections closed for exceeding the limit.
+}
+
+// newConnLimiter retrieves a limiter allowing max concurrent connections per source IP.
+func newConnLimiter(maxConns int) *connLimiter {
+	return &connLimiter{max: maxConns, conns: make(map[string]int)}
+}
+
+// sourceIP returns the IP address the connection comes from.
+func sourceIP(c net.Conn) string {
+	addr := c.RemoteAddr().String()
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+// connState counts the connections per source IP and closes new ones exceeding the limit.
+// It is meant to be used as http.Server.ConnState.
+func (l *connLimiter) connState(c net.Conn, state http.ConnState) {
+	ip := sourceIP(c)
+
+	switch state { //nolint:exhaustive // only opening and closing change the count
+	case http.StateNew:
+		l.mu.Lock()
+
+		l.conns[ip]++
+		over := l.conns[ip] > l.max
+
+		if over {
+			l.rejected++
+		}
+
+		l.mu.Unlock()
+
+		if over {
+			log.Warn("too many connections", "ip", ip, "limit", l.max)
+			c.Close()
+		}
+	case http.StateClosed, http.StateHijacked:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.conns[ip]--; l.conns[ip] <= 0 {
+			delete(l.conns, ip)
+		}
+	}
+}
+
+// Metric names of the connection limiter.
+const (
+	connsMetric         = "terraform_backend_connections"
+	rejectedConnsMetric = "terraform_backend_rejected_connections_total"
+)
+
+// topIPs returns the source IPs with the most connections.
+// The caller must hold l.mu.
+func (l *connLimiter) topIPs() []string {
+	ips := make([]string, 0, len(l.conns))
+	for ip := range l.conns {
+		ips = append(ips, ip)
+	}
+
+	slices.SortFunc(ips, func(a, b string) int {
+		return cmp.Or(cmp.Compare(l.conns[b], l.conns[a]), cmp.Compare(a, b))
+	})
+
+	return ips[:min(len(ips), topConnIPs)]
+}
+
+func (l *connLimiter) samples() []sample {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	samples := []sample{{name: rejectedConnsMetric, kind: metricCounter, value: float64(l.rejected)}}
+
+	for _, ip := range l.topIPs() {
+		samples = append(samples, sample{
+			name: connsMetric, kind: metricGauge, label: "ip", labelValue: ip, value: float64(l.conns[ip]),
+		})
+	}
+
+	return samples
+}
+
+func (l *connLimiter) write(w io.Writer) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err := fmt.Fprintf(w, "# HELP %s Open connections of the %d source IPs with the most.\n# TYPE %s gauge\n",
+		connsMetric, topConnIPs, connsMetric)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", connsMetric, err)
+	}
+
+	for _, ip := range l.topIPs() {
+		if _, err := fmt.Fprintf(w, "%s{ip=\"%s\"} %d\n", connsMetric, escapeLabelValue(ip), l.conns[ip]); err != nil {
+			return fmt.Errorf("failed to write %s: %w", connsMetric, err)
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "# HELP %s Connections closed for exceeding the per IP limit.\n# TYPE %s counter\n%s %d\n",
+		rejectedConnsMetric, rejectedConnsMetric, rejectedConnsMetric, l.rejected)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", rejectedConnsMetric, err)
+	}
+
+	return nil
+}
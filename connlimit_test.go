@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addrConn is a connection coming from the given remote address.
+type addrConn struct {
+	net.Conn
+
+	addr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func newAddrConn(t *testing.T, ip string) net.Conn {
+	t.Helper()
+
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+
+	return addrConn{Conn: conn, addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}}
+}
+
+// isClosed reports whether the connection has been closed.
+func isClosed(c net.Conn) bool {
+	c.SetWriteDeadline(time.Now())
+
+	_, err := c.Write([]byte{0})
+
+	return errors.Is(err, io.ErrClosedPipe)
+}
+
+func TestConnLimiter(t *testing.T) {
+	t.Parallel()
+
+	limiter := newConnLimiter(2)
+	conns := []net.Conn{
+		newAddrConn(t, "192.0.2.1"),
+		newAddrConn(t, "192.0.2.1"),
+		newAddrConn(t, "192.0.2.1"),
+		newAddrConn(t, "192.0.2.2"),
+	}
+
+	for _, c := range conns {
+		limiter.connState(c, http.StateNew)
+	}
+
+	for i, c := range conns {
+		if closed := isClosed(c); closed != (i == 2) {
+			t.Fatalf("unexpected state of connection %d: closed %v", i, closed)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err := limiter.write(&buf); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+
+	for _, line := range []string{
+		`terraform_backend_connections{ip="192.0.2.1"} 3`,
+		`terraform_backend_connections{ip="192.0.2.2"} 1`,
+		`terraform_backend_rejected_connections_total 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("metrics output does not contain %q:\n%s", line, buf.String())
+		}
+	}
+
+	for _, c := range conns[:3] {
+		limiter.connState(c, http.StateClosed)
+	}
+
+	next := newAddrConn(t, "192.0.2.1")
+	limiter.connState(next, http.StateNew)
+
+	if isClosed(next) {
+		t.Fatal("connection closed after others were")
+	}
+
+	if limiter.conns["192.0.2.1"] != 1 {
+		t.Fatalf("unexpected connection count: %d", limiter.conns["192.0.2.1"])
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	log "log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// connKey is the context key of the connection a request arrived on.
+type connKey struct{}
+
+// connContext stores the connection in the context of the requests arriving on it.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// holdLock ties the lock to the connection the LOCK request arrived on,
+// if locks are released on disconnect.
+func (s *Storage) holdLock(r *http.Request, name string) {
+	if !s.releaseOnDisconnect {
+		return
+	}
+
+	c, ok := r.Context().Value(connKey{}).(net.Conn)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lockConns[name] = c
+}
+
+// forgetLock unties the lock from its connection once the client released it.
+func (s *Storage) forgetLock(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.lockConns, name)
+}
+
+// connState releases the locks held by a connection once it is closed or hijacked.
+// It is meant to be used as http.Server.ConnState.
+func (s *Storage) connState(c net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, held := range s.lockConns {
+		if held != c {
+			continue
+		}
+
+		delete(s.lockConns, name)
+
+		err := os.Remove(filepath.Join(s.path, name+lockFileExt))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Error("failed to release lock", "name", name, "error", err)
+
+			continue
+		}
+
+		log.Warn("lock released on disconnect", "name", name, "remote", c.RemoteAddr())
+	}
+}
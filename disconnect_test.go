@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStorageReleaseOnDisconnect(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.releaseOnDisconnect = true
+
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+
+	req := httptest.NewRequest("LOCK", "/"+name, nil)
+	req = req.WithContext(connContext(context.Background(), conn))
+
+	w := httptest.NewRecorder()
+	storage.handleLock(w, req, name)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	storage.connState(conn, http.StateIdle)
+
+	if locked, err := storage.isLocked(name); err != nil || !locked {
+		t.Fatalf("lock released on idle connection: locked %v, error %v", locked, err)
+	}
+
+	storage.connState(peer, http.StateClosed)
+
+	if locked, err := storage.isLocked(name); err != nil || !locked {
+		t.Fatalf("lock released on another connection: locked %v, error %v", locked, err)
+	}
+
+	storage.connState(conn, http.StateClosed)
+
+	if locked, err := storage.isLocked(name); err != nil || locked {
+		t.Fatalf("lock not released on disconnect: locked %v, error %v", locked, err)
+	}
+}
+
+func TestStorageUnlockForgetsConnection(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.releaseOnDisconnect = true
+
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+
+	ctx := connContext(context.Background(), conn)
+
+	for _, method := range []string{"LOCK", "UNLOCK"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/"+name, nil).WithContext(ctx)
+		req.SetPathValue("name", name)
+
+		storage.handleState(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", method, w.Code, http.StatusOK)
+		}
+	}
+
+	if len(storage.lockConns) != 0 {
+		t.Fatalf("unexpected connections holding locks: %v", storage.lockConns)
+	}
+}
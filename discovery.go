@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	log "log/slog"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+const (
+	discoveryPath          = "/.well-known/terraform-http-backend" // Path of the discovery document.
+	discoverySchemaVersion = 1                                     // Version of the discovery document schema.
+	backendType            = "filesystem"                          // Type of the state storage.
+)
+
+// Capabilities represents the discovery document describing the server configuration.
+// Fields may be added within a schema version, they are only removed or changed in a new one.
+type Capabilities struct {
+	SchemaVersion    int      `json:"schemaVersion"`
+	Backend          string   `json:"backend"`
+	Methods          []string `json:"methods"`
+	Actions          []string `json:"actions"`
+	Locking          bool     `json:"locking"`
+	MaxLockBodyBytes int64    `json:"maxLockBodyBytes"`
+	Resumable        bool     `json:"resumable"`
+	Compression      []string `json:"compression"`
+	Versioning       bool     `json:"versioning"`
+	MaxStateBytes    int64    `json:"maxStateBytes"` // Zero if unlimited.
+	SigningRequired  bool     `json:"signingRequired"`
+	SafeMode         bool     `json:"safeMode"` // Whether writes are currently frozen.
+}
+
+// capabilities retrieves the discovery document of the storage.
+func (s *Storage) capabilities() Capabilities {
+	return Capabilities{
+		SchemaVersion:    discoverySchemaVersion,
+		Backend:          backendType,
+		Methods:          slices.Sorted(maps.Keys(s.stateHandlers())),
+		Actions:          slices.Sorted(maps.Keys(s.actionHandlers())),
+		Locking:          true,
+		MaxLockBodyBytes: s.maxLockBody,
+		Resumable:        s.resumable,
+		Compression:      append([]string{}, s.compression...),
+		Versioning:       false,
+		MaxStateBytes:    s.maxStateSize,
+		SigningRequired:  s.signer != nil,
+		SafeMode:         s.safeMode != nil && s.safeMode.enabled.Load(),
+	}
+}
+
+// handleDiscovery is HTTP handler for GET /.well-known/terraform-http-backend.
+// It describes the server capabilities so tooling can adapt to its configuration.
+func (s *Storage) handleDiscovery(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(s.capabilities()); err != nil {
+		log.Error("failed to encode JSON:", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestStorageHandleDiscovery(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.resumable = true
+	storage.compression = []string{encodingGzip}
+	storage.maxStateSize = 1024
+	storage.signer = newSigner("secret")
+	storage.safeMode = newSafeMode(true, "")
+
+	mux := http.NewServeMux()
+	storage.registerRoutes(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, discoveryPath, nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var got Capabilities
+
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if got.SchemaVersion != discoverySchemaVersion || got.Backend != backendType || !got.Locking || !got.Resumable {
+		t.Fatalf("unexpected capabilities: %+v", got)
+	}
+
+	wantMethods := []string{http.MethodDelete, http.MethodGet, "LOCK", http.MethodPost, "UNLOCK"}
+	if !slices.Equal(got.Methods, wantMethods) {
+		t.Fatalf("unexpected methods: got %v, want %v", got.Methods, wantMethods)
+	}
+
+	if !slices.Contains(got.Actions, "POST touch") {
+		t.Fatalf("unexpected actions: %v", got.Actions)
+	}
+
+	if got.MaxLockBodyBytes != defaultMaxLockBody || !slices.Equal(got.Compression, []string{encodingGzip}) {
+		t.Fatalf("unexpected limits: %+v", got)
+	}
+
+	if got.MaxStateBytes != 1024 || !got.SigningRequired || !got.SafeMode {
+		t.Fatalf("unexpected write requirements: %+v", got)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "log/slog"
+	"net/http"
+)
+
+// checkFreeSpace returns ErrStorageFull if writing size bytes would leave less than minFreeSpace bytes free.
+// Writes are let through if the free space can't be determined.
+func (s *Storage) checkFreeSpace(size int) error {
+	if s.minFreeSpace <= 0 {
+		return nil
+	}
+
+	free, err := s.diskFree(s.path)
+	if err != nil {
+		log.Warn("failed to check free space", "path", s.path, "error", err)
+
+		return nil
+	}
+
+	if need := uint64(size) + uint64(s.minFreeSpace); free < need {
+		return fmt.Errorf("%w: %d bytes free, %d bytes required", ErrStorageFull, free, need)
+	}
+
+	return nil
+}
+
+// freeSpaceBytes retrieves the free space on the storage file system as a metric value.
+func (s *Storage) freeSpaceBytes() (float64, error) {
+	free, err := s.diskFree(s.path)
+
+	return float64(free), err
+}
+
+// Stats represents the storage statistics.
+type Stats struct {
+	Status            string  `json:"status"`
+	FreeSpaceBytes    *uint64 `json:"freeSpaceBytes,omitempty"`
+	MinFreeSpaceBytes int64   `json:"minFreeSpaceBytes"`
+	Locks             *int    `json:"locks,omitempty"`
+	MaxLocks          int64   `json:"maxLocks"`
+}
+
+// handleStats is HTTP handler for GET /stats.
+// The free space and the number of locks are left out if they can't be determined.
+func (s *Storage) handleStats(w http.ResponseWriter, _ *http.Request) {
+	stats := Stats{Status: "ok", MinFreeSpaceBytes: s.minFreeSpace, MaxLocks: s.maxLocks}
+
+	if locks, err := s.countLocks(); err != nil {
+		log.Debug("number of locks not available", "path", s.path, "error", err)
+	} else {
+		stats.Locks = &locks
+	}
+
+	if free, err := s.diskFree(s.path); err != nil {
+		log.Debug("free space not available", "path", s.path, "error", err)
+	} else {
+		stats.FreeSpaceBytes = &free
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Error("failed to encode JSON:", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
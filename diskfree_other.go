@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// diskFree is not supported on this platform, the free space guard is disabled.
+func diskFree(path string) (uint64, error) {
+	return 0, fmt.Errorf("failed to stat file system of %s: %w", path, errors.ErrUnsupported)
+}
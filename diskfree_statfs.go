@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// diskFree retrieves the number of bytes available to unprivileged users on the file system holding path.
+func diskFree(path string) (uint64, error) {
+	var st syscall.Statfs_t
+
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, fmt.Errorf("failed to stat file system of %s: %w", path, err)
+	}
+
+	return uint64(st.Bavail) * uint64(st.Bsize), nil //nolint:gosec,unconvert // field types differ by platform
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStorageHandlePostMinFreeSpace(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.minFreeSpace = 1000
+	storage.diskFree = func(string) (uint64, error) { return 1005, nil }
+
+	testCases := []struct {
+		body string
+		want int
+	}{
+		{"12345", http.StatusCreated},
+		{"123456", http.StatusInsufficientStorage},
+	}
+
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		storage.handlePost(w, httptest.NewRequest(http.MethodPost, "/"+name, bytes.NewBufferString(tc.body)), name)
+
+		if w.Code != tc.want {
+			t.Fatalf("unexpected status code for %d bytes: got %d, want %d", len(tc.body), w.Code, tc.want)
+		}
+	}
+
+	storage.diskFree = func(string) (uint64, error) { return 0, errors.ErrUnsupported }
+
+	w := httptest.NewRecorder()
+	storage.handlePost(w, httptest.NewRequest(http.MethodPost, "/"+name, bytes.NewBufferString("content")), name)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code without free space: got %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestStorageHandleStats(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.minFreeSpace = 1000
+	storage.maxLocks = 5
+	storage.diskFree = func(string) (uint64, error) { return 4096, nil }
+
+	if err := storage.createLock(name, []byte(`{"ID":"held"}`)); err != nil {
+		t.Fatalf("failed to create lock: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	storage.handleStats(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+
+	var stats Stats
+
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if stats.FreeSpaceBytes == nil || *stats.FreeSpaceBytes != 4096 || stats.MinFreeSpaceBytes != 1000 ||
+		stats.Locks == nil || *stats.Locks != 1 || stats.MaxLocks != 5 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	w = httptest.NewRecorder()
+	storage.metrics.handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %v", err)
+	}
+
+	if line := "terraform_backend_free_space_bytes 4096\n"; !strings.Contains(string(body), line) {
+		t.Fatalf("metrics output does not contain %q:\n%s", line, body)
+	}
+}
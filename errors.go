@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"os"
+	"syscall"
+)
+
+// Storage errors beyond the state bookkeeping ones, mapped to HTTP statuses by writeError.
+// Missing and locked states are reported with ErrNotExists and ErrAlreadyLocked.
+// ErrConflict is returned for operations clashing with another state or upload,
+// such as moving or copying onto an existing state.
+var (
+	ErrConflict      = errors.New("conflicting state operation")
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	ErrStorageFull   = errors.New("storage full")
+	ErrReadOnly      = errors.New("storage is read-only")
+	ErrCorrupt       = errors.New("corrupt state")
+	ErrTooManyLocks  = errors.New("too many concurrent locks")
+)
+
+// storageError classifies a file system error as one of the storage errors.
+// Errors it doesn't recognize are returned unchanged.
+func storageError(err error) error {
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		return fmt.Errorf("%w: %w", ErrStorageFull, err)
+	case errors.Is(err, syscall.EDQUOT):
+		return fmt.Errorf("%w: %w", ErrQuotaExceeded, err)
+	case errors.Is(err, syscall.EROFS):
+		return fmt.Errorf("%w: %w", ErrReadOnly, err)
+	default:
+		return err
+	}
+}
+
+// errorStatus returns the HTTP status code and message a storage error is answered with.
+func errorStatus(err error) (int, string) {
+	err = storageError(err)
+
+	switch {
+	case errors.Is(err, ErrNotExists), errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound, "Not Found"
+	case errors.Is(err, ErrAlreadyLocked):
+		return http.StatusLocked, "Locked"
+	case errors.Is(err, ErrShrink):
+		return http.StatusConflict, "Conflict: state shrinks, retry with allow-shrink=true if intended"
+	case errors.Is(err, ErrConflict), errors.Is(err, ErrAlreadyUnlocked), errors.Is(err, ErrAlreadyExists):
+		return http.StatusConflict, "Conflict"
+	case errors.Is(err, ErrQuotaExceeded):
+		return http.StatusInsufficientStorage, "Insufficient Storage: quota exceeded"
+	case errors.Is(err, ErrStorageFull):
+		return http.StatusInsufficientStorage, "Insufficient Storage: storage full"
+	case errors.Is(err, ErrReadOnly):
+		return http.StatusServiceUnavailable, "Service Unavailable: storage is read-only"
+	case errors.Is(err, ErrTooManyLocks):
+		return http.StatusServiceUnavailable, "Service Unavailable: too many concurrent locks, retry once some are released"
+	case errors.Is(err, ErrCorrupt), errors.Is(err, ErrInconsistent):
+		return http.StatusInternalServerError, "Internal Server Error: corrupt storage"
+	default:
+		return http.StatusInternalServerError, "Internal Server Error"
+	}
+}
+
+// writeError logs the storage error and responds with the status it maps to.
+// Server errors are logged as errors, client errors as warnings.
+func writeError(w http.ResponseWriter, msg, name string, err error) {
+	code, text := errorStatus(err)
+
+	if code >= http.StatusInternalServerError {
+		log.Error(msg, "name", name, "status", code, "error", err)
+	} else {
+		log.Warn(msg, "name", name, "status", code, "error", err)
+	}
+
+	http.Error(w, text, code)
+}
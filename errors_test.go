@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	t.Parallel()
+
+	pathErr := func(errno syscall.Errno) error {
+		return fmt.Errorf("failed to commit: %w", &fs.PathError{Op: "rename", Path: "test.tfstate", Err: errno})
+	}
+
+	testCases := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"not found", ErrNotExists, http.StatusNotFound},
+		{"missing file", pathErr(syscall.ENOENT), http.StatusNotFound},
+		{"locked", ErrAlreadyLocked, http.StatusLocked},
+		{"conflict", ErrConflict, http.StatusConflict},
+		{"unlocked", ErrAlreadyUnlocked, http.StatusConflict},
+		{"quota exceeded", ErrQuotaExceeded, http.StatusInsufficientStorage},
+		{"quota errno", pathErr(syscall.EDQUOT), http.StatusInsufficientStorage},
+		{"storage full", ErrStorageFull, http.StatusInsufficientStorage},
+		{"storage full errno", pathErr(syscall.ENOSPC), http.StatusInsufficientStorage},
+		{"read-only", ErrReadOnly, http.StatusServiceUnavailable},
+		{"read-only errno", pathErr(syscall.EROFS), http.StatusServiceUnavailable},
+		{"too many locks", ErrTooManyLocks, http.StatusServiceUnavailable},
+		{"corrupt", ErrCorrupt, http.StatusInternalServerError},
+		{"inconsistent", unexpectedFileType("test.tfstate", fs.ModeDir), http.StatusInternalServerError},
+		{"unknown", errors.New("unknown"), http.StatusInternalServerError},
+		{"permission", pathErr(syscall.EACCES), http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		if got, _ := errorStatus(tc.err); got != tc.want {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	writeError(w, "failed to write file", name, fmt.Errorf("failed to write: %w", syscall.ENOSPC))
+
+	if w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusInsufficientStorage)
+	}
+
+	if want := "Insufficient Storage: storage full\n"; w.Body.String() != want {
+		t.Fatalf("unexpected response body: got %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestStorageHandleDeleteMissing(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	w := httptest.NewRecorder()
+	storage.handleDelete(w, httptest.NewRequest(http.MethodDelete, "/"+name, nil), name)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
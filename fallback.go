@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	fallbackReadTimeout = 5 * time.Second // Maximum time to wait for a read from the fallback storage.
+	tombstoneFileExt    = ".deleted"      // Marks a state deleted from the storage, see buryState.
+)
+
+var ErrFallbackTimeout = errors.New("fallback read timed out")
+
+// readFallback reads the state from the fallback storage directory.
+// The read is abandoned once ctx is done or fallbackReadTimeout elapses.
+func (s *Storage) readFallback(ctx context.Context, name string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, fallbackReadTimeout)
+	defer cancel()
+
+	type result struct {
+		data []byte
+		err  error
+	}
+
+	ch := make(chan result, 1)
+
+	go func() {
+		data, err := os.ReadFile(filepath.Join(s.fallback, name+stateFileExt))
+		ch <- result{data, err}
+	}()
+
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			return nil, fmt.Errorf("failed to read fallback state %s: %w", name, res.err)
+		}
+
+		return res.data, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %s: %w", ErrFallbackTimeout, name, ctx.Err())
+	}
+}
+
+// buryState records that the state is deleted from the storage, if a fallback storage is configured.
+// Without the record the state would be read from the fallback storage again, and written back if populating.
+// It is only looked at while the state is missing, so a state written again doesn't need it removed.
+func (s *Storage) buryState(name string) error {
+	if s.fallback == "" {
+		return nil
+	}
+
+	return s.writeFile(filepath.Join(s.path, name+tombstoneFileExt), nil)
+}
+
+// inFallback reports whether the state exists in the fallback storage, if configured.
+func (s *Storage) inFallback(name string) bool {
+	if s.fallback == "" {
+		return false
+	}
+
+	exists, err := fileExists(filepath.Join(s.fallback, name+stateFileExt))
+
+	return err == nil && exists
+}
+
+// readState reads the state from the storage.
+// States missing from the storage are read from the fallback storage if configured, unless they were deleted,
+// and copied into the storage if populating is enabled.
+func (s *Storage) readState(ctx context.Context, name string) ([]byte, error) {
+	filePath := filepath.Join(s.path, name+stateFileExt)
+
+	data, err := os.ReadFile(filePath)
+	if err == nil {
+		return data, nil
+	}
+
+	if s.fallback == "" || !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read state %s: %w", name, err)
+	}
+
+	buried, buriedErr := fileExists(filepath.Join(s.path, name+tombstoneFileExt))
+	if buriedErr != nil {
+		return nil, buriedErr
+	}
+
+	if buried {
+		return nil, fmt.Errorf("failed to read deleted state %s: %w", name, err)
+	}
+
+	data, err = s.readFallback(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("state read from fallback storage", "name", name, "fallback", s.fallback)
+
+	if !s.populate {
+		return data, nil
+	}
+
+	// The state is only created, a state written meanwhile is newer than the fallback one and read instead.
+	err = s.createFile(filePath, data)
+	switch {
+	case errors.Is(err, ErrAlreadyExists):
+		log.Debug("state already populated", "name", name)
+
+		if current, err := os.ReadFile(filePath); err == nil {
+			return current, nil
+		}
+	case err != nil:
+		log.Error("failed to populate state from fallback storage", "name", name, "error", err)
+	default:
+		log.Info("state populated from fallback storage", "name", name)
+	}
+
+	return data, nil
+}
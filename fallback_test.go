@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStorageHandleGetFallback(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.fallback = t.TempDir()
+
+	filePath := filepath.Join(storage.fallback, name+stateFileExt)
+	if err := os.WriteFile(filePath, []byte("archived"), defaultFileMode); err != nil {
+		t.Fatalf("failed to write fallback state: %v", err)
+	}
+
+	for _, state := range []string{name, "missing"} {
+		w := httptest.NewRecorder()
+
+		storage.handleGet(w, httptest.NewRequest(http.MethodGet, "/"+state, nil), state)
+
+		want := http.StatusOK
+		if state == "missing" {
+			want = http.StatusNotFound
+		}
+
+		if w.Code != want {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", state, w.Code, want)
+		}
+	}
+
+	if exists, _ := storage.exists(name); exists {
+		t.Fatal("state populated with populating disabled")
+	}
+
+	storage.populate = true
+
+	w := httptest.NewRecorder()
+
+	storage.handleGet(w, httptest.NewRequest(http.MethodGet, "/"+name, nil), name)
+
+	if w.Body.String() != "archived" {
+		t.Fatalf("unexpected response body: got %q, want %q", w.Body.String(), "archived")
+	}
+
+	if exists, _ := storage.exists(name); !exists {
+		t.Fatal("state not populated from fallback storage")
+	}
+}
+
+func TestStorageHandleDeleteFallback(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.fallback = t.TempDir()
+	storage.populate = true
+
+	for _, state := range []string{name, "archived"} {
+		writeTestFile(t, filepath.Join(storage.fallback, state+stateFileExt), state)
+	}
+
+	get := func(state string) int {
+		w := httptest.NewRecorder()
+		storage.handleGet(w, httptest.NewRequest(http.MethodGet, "/"+state, nil), state)
+
+		return w.Code
+	}
+
+	// The first state is populated into the storage, the other one only lives in the fallback storage.
+	if code := get(name); code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", code, http.StatusOK)
+	}
+
+	for _, state := range []string{name, "archived"} {
+		w := httptest.NewRecorder()
+		storage.handleDelete(w, httptest.NewRequest(http.MethodDelete, "/"+state, nil), state)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status code deleting %s: got %d, want %d", state, w.Code, http.StatusOK)
+		}
+
+		if code := get(state); code != http.StatusNotFound {
+			t.Fatalf("unexpected status code reading deleted %s: got %d, want %d", state, code, http.StatusNotFound)
+		}
+
+		if exists, _ := storage.exists(state); exists {
+			t.Fatalf("deleted %s populated again from fallback storage", state)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	storage.handlePost(w, httptest.NewRequest(http.MethodPost, "/"+name, strings.NewReader("recreated")), name)
+
+	if code := get(name); w.Code != http.StatusCreated || code != http.StatusOK {
+		t.Fatalf("unexpected status codes recreating the state: got %d and %d", w.Code, code)
+	}
+}
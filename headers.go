@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var ErrInvalidHeader = errors.New("invalid response header")
+
+// reservedHeaders are the headers describing the response body, set by the handlers only.
+func reservedHeaders() []string {
+	return []string{"Content-Type", "Content-Length", "Content-Encoding", "Content-Range", "Transfer-Encoding", "ETag"}
+}
+
+// isToken reports whether v is a valid HTTP header name.
+func isToken(v string) bool {
+	if v == "" {
+		return false
+	}
+
+	for _, c := range v {
+		if c > '~' || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseResponseHeaders parses the headers to add to every response, given as "Name: value".
+func parseResponseHeaders(headers []string) (http.Header, error) {
+	parsed := http.Header{}
+
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+
+		switch {
+		case !ok || !isToken(name):
+			return nil, fmt.Errorf("%w %q: must be Name: value", ErrInvalidHeader, h)
+		case strings.ContainsAny(value, "\r\n\x00"):
+			return nil, fmt.Errorf("%w %q: value contains control characters", ErrInvalidHeader, h)
+		}
+
+		for _, reserved := range reservedHeaders() {
+			if strings.EqualFold(name, reserved) {
+				return nil, fmt.Errorf("%w %q: %s is set by the server", ErrInvalidHeader, h, reserved)
+			}
+		}
+
+		parsed.Add(name, value)
+	}
+
+	return parsed, nil
+}
+
+// headerWriter adds headers to the response once the handler set its own.
+type headerWriter struct {
+	http.ResponseWriter
+
+	headers http.Header // Headers to add.
+	added   bool        // Whether the headers were added.
+}
+
+// addHeaders adds the headers not set by the handler.
+func (hw *headerWriter) addHeaders() {
+	if hw.added {
+		return
+	}
+
+	hw.added = true
+
+	for name, values := range hw.headers {
+		if _, set := hw.Header()[name]; !set {
+			hw.Header()[name] = values
+		}
+	}
+}
+
+func (hw *headerWriter) WriteHeader(code int) {
+	hw.addHeaders()
+	hw.ResponseWriter.WriteHeader(code)
+}
+
+func (hw *headerWriter) Write(b []byte) (int, error) {
+	hw.addHeaders()
+
+	return hw.ResponseWriter.Write(b) //nolint:wrapcheck // the error is the one of the wrapped ResponseWriter
+}
+
+// Unwrap returns the wrapped ResponseWriter for http.ResponseController.
+func (hw *headerWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// addResponseHeaders wraps the handler to add the headers to every response.
+// Headers the handler sets itself are left as they are.
+func addResponseHeaders(headers http.Header, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &headerWriter{ResponseWriter: w, headers: headers}
+		next.ServeHTTP(hw, r)
+
+		// A handler that writes nothing still gets a response.
+		hw.addHeaders()
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseResponseHeaders(t *testing.T) {
+	t.Parallel()
+
+	headers, err := parseResponseHeaders([]string{
+		"Strict-Transport-Security: max-age=31536000; includeSubDomains",
+		"x-content-type-options:nosniff",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := headers.Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Fatalf("unexpected header value: %q", got)
+	}
+
+	for _, h := range []string{"no colon", ": value", "Bad Name: value", "Content-Type: text/html", "etag: x"} {
+		if _, err := parseResponseHeaders([]string{h}); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("header %q accepted: %v", h, err)
+		}
+	}
+}
+
+func TestAddResponseHeaders(t *testing.T) {
+	t.Parallel()
+
+	headers := http.Header{}
+	headers.Set("X-Content-Type-Options", "nosniff")
+	headers.Set("Cache-Control", "no-store")
+
+	storage := setupTestStorage(t)
+	mux := http.NewServeMux()
+	storage.registerRoutes(mux)
+	mux.HandleFunc("GET /cached", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+	})
+
+	handler := addResponseHeaders(headers, mux)
+
+	for target, cache := range map[string]string{"/": "no-store", "/missing": "no-store", "/cached": "max-age=60"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+		if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("header not added to %s: %q", target, got)
+		}
+
+		if got := w.Header().Get("Cache-Control"); got != cache {
+			t.Errorf("unexpected Cache-Control of %s: got %q, want %q", target, got, cache)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"os"
+)
+
+// HealthCheck verifies the storage is writable by creating and removing a probe file.
+func (s *Storage) HealthCheck(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("health check canceled: %w", err)
+	}
+
+	fh, err := os.CreateTemp(s.path, testFileName+"-*"+tmpFileExt)
+	if err != nil {
+		return fmt.Errorf("insufficient permissions for reading and writing in %s: %w", s.path, err)
+	}
+
+	if err := fh.Close(); err != nil {
+		os.Remove(fh.Name())
+
+		return fmt.Errorf("failed close testfile %s: %w", fh.Name(), err)
+	}
+
+	if err := os.Remove(fh.Name()); err != nil {
+		return fmt.Errorf("failed remove testfile %s: %w", fh.Name(), err)
+	}
+
+	return nil
+}
+
+// handleReady is HTTP handler for GET /readyz.
+// It answers 503 Service Unavailable while the storage fails its health check.
+func (s *Storage) handleReady(w http.ResponseWriter, r *http.Request) {
+	if err := s.HealthCheck(r.Context()); err != nil {
+		log.Error("health check failed", "path", s.path, "error", err)
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
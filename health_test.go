@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestStorageHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	if err := storage.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries, err := os.ReadDir(storage.path)
+	if err != nil {
+		t.Fatalf("failed to read storage directory: %v", err)
+	}
+
+	if len(entries) != 0 {
+		t.Fatalf("probe file left behind: %s", entries[0].Name())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := storage.HealthCheck(ctx); err == nil {
+		t.Fatal("expected error for canceled context")
+	}
+}
+
+func TestStorageHandleReady(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	w := httptest.NewRecorder()
+	storage.handleReady(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if err := os.RemoveAll(storage.path); err != nil {
+		t.Fatalf("failed to remove storage directory: %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	storage.handleReady(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code for missing storage: got %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	log "log/slog"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+)
+
+// Form fields of an import.
+const (
+	importStateField = "state" // The state file.
+	importTagsField  = "tags"  // The tags of the state as a JSON object, optional.
+)
+
+var ErrStateTooLarge = errors.New("state too large")
+
+// readPart reads the multipart part, failing with ErrStateTooLarge if it is larger than limit bytes.
+// A limit of zero or less reads the part whole.
+func readPart(part *multipart.Part, limit int64) ([]byte, error) {
+	var r io.Reader = part
+	if limit > 0 {
+		r = io.LimitReader(part, limit+1)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", part.FormName(), err)
+	}
+
+	if limit > 0 && int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrStateTooLarge, part.FormName(), limit)
+	}
+
+	return data, nil
+}
+
+// readImport retrieves the state and, if present, the tags of an import form.
+func (s *Storage) readImport(r *http.Request) ([]byte, map[string]string, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read form: %w", err)
+	}
+
+	var (
+		data []byte
+		tags map[string]string
+	)
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read form: %w", err)
+		}
+
+		switch part.FormName() {
+		case importStateField:
+			data, err = readPart(part, s.maxStateSize)
+		case importTagsField:
+			var raw []byte
+
+			if raw, err = readPart(part, maxTagsBody); err == nil {
+				tags, err = parseTags(raw)
+			}
+		default:
+			log.Debug("import form field ignored", "field", part.FormName())
+		}
+
+		part.Close()
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if data == nil {
+		return nil, nil, fmt.Errorf("%w: missing %s field", http.ErrMissingFile, importStateField)
+	}
+
+	return data, tags, nil
+}
+
+// handleImport is HTTP handler for POST /import/{name}.
+// It writes the state uploaded as the state field of a multipart form, e.g. with curl -F state=@file.
+// Tags may be set along with it in the tags field. An existing state is only replaced with overwrite=true,
+// subject to the shrink guard like POST.
+func (s *Storage) handleImport(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	name, ok := s.pathName(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+
+	overwrite, err := strconv.ParseBool(query.Get("overwrite"))
+	if err != nil && query.Has("overwrite") {
+		http.Error(w, "Bad Request: invalid overwrite", http.StatusBadRequest)
+
+		return
+	}
+
+	// Locked states are rejected before the upload is read.
+	if !s.checkUnlocked(w, name) || !s.checkSignature(w, r, name) {
+		return
+	}
+
+	data, tags, err := s.readImport(r)
+	if errors.Is(err, ErrStateTooLarge) {
+		log.Warn("imported state too large", "name", name, "error", err)
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	if err != nil {
+		log.Warn("invalid import", "name", name, "error", err)
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if !s.checkState(w, r, name, data) {
+		return
+	}
+
+	filePath := filepath.Join(s.path, name+stateFileExt)
+
+	exists, err := s.exists(name)
+	if err == nil {
+		if overwrite {
+			err = s.writeFile(filePath, data)
+		} else {
+			err = s.createFile(filePath, data)
+		}
+	}
+
+	if err != nil {
+		writeError(w, "failed to import state", name, err)
+
+		return
+	}
+
+	if tags != nil {
+		if err := s.writeTags(name, tags); err != nil {
+			writeError(w, "failed to write tags", name, err)
+
+			return
+		}
+	}
+
+	audit(r, "state imported", "name", name, "overwrite", overwrite, "size", len(data))
+
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
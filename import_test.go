@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// importRequest retrieves a POST /import request with the multipart form of the fields.
+func importRequest(t *testing.T, target string, fields map[string]string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+
+	mw := multipart.NewWriter(&body)
+
+	for field, value := range fields {
+		fw, err := mw.CreateFormFile(field, field+".json")
+		if err != nil {
+			t.Fatalf("failed to create form field: %v", err)
+		}
+
+		if _, err := fw.Write([]byte(value)); err != nil {
+			t.Fatalf("failed to write form field: %v", err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		t.Fatalf("failed to close form: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return req
+}
+
+func TestStorageHandleImport(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.maxStateSize = 16
+
+	mux := http.NewServeMux()
+	storage.registerRoutes(mux)
+
+	testCases := []struct {
+		name   string
+		target string
+		fields map[string]string
+		want   int
+	}{
+		{"created", "/import/test", map[string]string{"state": "v1", "tags": `{"env":"prod"}`}, http.StatusCreated},
+		{"exists", "/import/test", map[string]string{"state": "v2"}, http.StatusConflict},
+		{"overwrite", "/import/test?overwrite=true", map[string]string{"state": "v3"}, http.StatusOK},
+		{"invalid overwrite", "/import/test?overwrite=maybe", map[string]string{"state": "v4"}, http.StatusBadRequest},
+		{"missing state", "/import/other", map[string]string{"tags": `{"env":"prod"}`}, http.StatusBadRequest},
+		{"invalid tags", "/import/other", map[string]string{"state": "v1", "tags": `["env"]`}, http.StatusBadRequest},
+		{"too large", "/import/other", map[string]string{"state": strings.Repeat("x", 17)}, http.StatusRequestEntityTooLarge},
+		{"invalid name", "/import/a%5Cb", map[string]string{"state": "v1"}, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, importRequest(t, tc.target, tc.fields))
+
+		if w.Code != tc.want {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(storage.path, name+stateFileExt))
+	if err != nil || string(data) != "v3" {
+		t.Fatalf("unexpected state: %q, error %v", data, err)
+	}
+
+	if tags, err := storage.readTags(name); err != nil || tags["env"] != "prod" {
+		t.Fatalf("unexpected tags: %v, error %v", tags, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(storage.path, "other"+stateFileExt)); !os.IsNotExist(err) {
+		t.Fatalf("rejected import written: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(storage.path, name+lockFileExt), nil, defaultFileMode); err != nil {
+		t.Fatalf("failed to write lock file: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, importRequest(t, "/import/test?overwrite=true", map[string]string{"state": "v5"}))
+
+	if w.Code != http.StatusLocked {
+		t.Fatalf("unexpected status code for locked state: got %d, want %d", w.Code, http.StatusLocked)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	log "log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const lockIDHeader = "X-Terraform-Lock-ID" // Header carrying the lock ID for clients that can't send it in the body.
+
+// LockInfo represents the lock information Terraform sends with LOCK requests.
+//
+//nolint:tagliatelle // field names are defined by Terraform
+type LockInfo struct {
+	ID        string    `json:"ID"`
+	Operation string    `json:"Operation"`
+	Info      string    `json:"Info"`
+	Who       string    `json:"Who"`
+	Version   string    `json:"Version"`
+	Created   time.Time `json:"Created"`
+	Path      string    `json:"Path"`
+
+	// Acquired is the time the server granted the lock, added to the lock info sent by the client.
+	Acquired time.Time `json:"Acquired"`
+}
+
+// stampLock returns the lock info with the acquisition time set.
+// Lock info that isn't a JSON object is returned as is, the lock file time stands in for it then.
+func stampLock(data []byte, acquired time.Time) []byte {
+	info := map[string]json.RawMessage{}
+
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &info); err != nil {
+			return data
+		}
+	}
+
+	stamp, err := json.Marshal(acquired.UTC())
+	if err != nil {
+		return data
+	}
+
+	info["Acquired"] = stamp
+
+	stamped, err := json.Marshal(info)
+	if err != nil {
+		return data
+	}
+
+	return stamped
+}
+
+// parseLockInfo parses the lock information stored in a lock file.
+// Locks created without lock information yield an empty LockInfo.
+func parseLockInfo(data []byte) (LockInfo, error) {
+	var info LockInfo
+
+	if len(data) == 0 {
+		return info, nil
+	}
+
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("failed to parse lock info: %w", err)
+	}
+
+	return info, nil
+}
+
+// Lock represents a lock held on a state.
+type Lock struct {
+	Name     string    `json:"name"`
+	LockedAt time.Time `json:"lockedAt"`
+	ID       string    `json:"id,omitempty"`
+	Who      string    `json:"who,omitempty"`
+}
+
+// listLocks retrieves all locks held in the storage.
+func (s *Storage) listLocks() ([]Lock, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", s.path, err)
+	}
+
+	var locks []Lock
+
+	err = processEntries(s.path, entries, lockFileExt, func(name string, info fs.FileInfo) error {
+		lock := Lock{Name: name, LockedAt: info.ModTime()}
+
+		data, err := os.ReadFile(filepath.Join(s.path, info.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read lock file %s: %w", info.Name(), err)
+		}
+
+		// Lock info is informational here, a lock with unparsable info is still a lock.
+		if li, err := parseLockInfo(data); err == nil {
+			lock.ID, lock.Who = li.ID, strings.TrimSpace(li.Who)
+		}
+
+		locks = append(locks, lock)
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list locks: %w", err)
+	}
+
+	return locks, nil
+}
+
+// lockAcquired retrieves the time the lock of the state was acquired.
+// Locks stored without an acquisition time fall back to the modification time of the lock file.
+func (s *Storage) lockAcquired(name string) (time.Time, bool, error) {
+	f, err := os.Open(filepath.Join(s.path, name+lockFileExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, false, nil
+	}
+
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to open lock file of %s: %w", name, err)
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to stat lock file of %s: %w", name, err)
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to read lock file of %s: %w", name, err)
+	}
+
+	if info, err := parseLockInfo(data); err == nil && !info.Acquired.IsZero() {
+		return info.Acquired, true, nil
+	}
+
+	return fi.ModTime(), true, nil
+}
+
+// expireLock force-releases the lock of the state once it is held longer than lockMaxLifetime.
+// Expiry is serialized with lock creation, so a lock acquired meanwhile is never removed in place of the expired one.
+func (s *Storage) expireLock(name string) error {
+	if s.lockMaxLifetime <= 0 {
+		return nil
+	}
+
+	s.lockMu.Lock()
+	defer s.lockMu.Unlock()
+
+	return s.removeExpiredLock(name)
+}
+
+// removeExpiredLock removes the lock of the state if it is held longer than lockMaxLifetime.
+// The caller must hold s.lockMu.
+func (s *Storage) removeExpiredLock(name string) error {
+	acquired, locked, err := s.lockAcquired(name)
+	if err != nil || !locked {
+		return err
+	}
+
+	lockFile := filepath.Join(s.path, name+lockFileExt)
+
+	age := time.Since(acquired)
+	if age <= s.lockMaxLifetime {
+		return nil
+	}
+
+	if err := os.Remove(lockFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove lock file of %s: %w", name, err)
+	}
+
+	s.forgetLock(name)
+	log.Warn("lock expired", "name", name, "age", age.Round(time.Second), "maxLifetime", s.lockMaxLifetime)
+
+	return nil
+}
+
+// countLocks retrieves the number of locked states.
+func (s *Storage) countLocks() (int, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read directory %s: %w", s.path, err)
+	}
+
+	count := 0
+
+	err = processEntries(s.path, entries, lockFileExt, func(string, fs.FileInfo) error {
+		count++
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count locks: %w", err)
+	}
+
+	return count, nil
+}
+
+// expireLocks removes the locks held longer than lockMaxLifetime.
+// The caller must hold s.lockMu.
+func (s *Storage) expireLocks() error {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %s: %w", s.path, err)
+	}
+
+	err = processEntries(s.path, entries, lockFileExt, func(name string, _ fs.FileInfo) error {
+		return s.removeExpiredLock(name)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to expire locks: %w", err)
+	}
+
+	return nil
+}
+
+// createLimitedLock creates the lock file unless maxLocks states are locked already.
+// The lock info is stamped with the acquisition time lockMaxLifetime is measured from.
+// Counting and creating are serialized, so concurrent LOCK requests can't exceed the limit together,
+// and expired locks are swept first, so they don't count against it.
+func (s *Storage) createLimitedLock(name string, info []byte) error {
+	s.lockMu.Lock()
+	defer s.lockMu.Unlock()
+
+	info = stampLock(info, time.Now())
+
+	if s.maxLocks <= 0 {
+		return s.createLock(name, info)
+	}
+
+	if s.lockMaxLifetime > 0 {
+		if err := s.expireLocks(); err != nil {
+			return err
+		}
+	}
+
+	count, err := s.countLocks()
+	if err != nil {
+		return err
+	}
+
+	if int64(count) >= s.maxLocks {
+		return fmt.Errorf("%w: %d of %d states locked", ErrTooManyLocks, count, s.maxLocks)
+	}
+
+	return s.createLock(name, info)
+}
+
+// heldLock retrieves the lock info of the state if it is locked with the ID.
+// Returns false if the state isn't locked, the ID is empty or the lock has another ID.
+func (s *Storage) heldLock(name, id string) ([]byte, bool, error) {
+	if id == "" {
+		return nil, false, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.path, name+lockFileExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read lock file of %s: %w", name, err)
+	}
+
+	info, err := parseLockInfo(data)
+	if err != nil || info.ID != id {
+		return nil, false, nil //nolint:nilerr // a lock with unparsable info is held by an unknown ID
+	}
+
+	return data, true, nil
+}
+
+// handleGetLock is HTTP handler for GET /{name}/lock, only served in debug mode.
+// It responds with the lock info as stored, or 404 if the state isn't locked.
+func (s *Storage) handleGetLock(w http.ResponseWriter, _ *http.Request, name string) {
+	data, err := os.ReadFile(filepath.Join(s.path, name+lockFileExt))
+	if err != nil {
+		writeError(w, "failed to read lock file", name, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := w.Write(data); err != nil {
+		log.Error("failed to write response", "name", name, "error", err)
+	}
+}
+
+// retryAfter returns the seconds a client contending for a lock is told to wait,
+// randomly between base and twice base so contending clients don't all retry at once.
+func retryAfter(base time.Duration) int {
+	return int(math.Ceil(base.Seconds() * (1 + rand.Float64()))) //nolint:gosec // jitter needs no secure randomness
+}
+
+// lockContended responds to a LOCK request for a state locked by another client.
+// It is 423 Locked, or 429 Too Many Requests with a jittered Retry-After if lockRetryAfter is set.
+func (s *Storage) lockContended(w http.ResponseWriter, name string) {
+	if s.lockRetryAfter <= 0 {
+		log.Warn("state already locked", "name", name)
+		http.Error(w, "Locked", http.StatusLocked)
+
+		return
+	}
+
+	after := retryAfter(s.lockRetryAfter)
+
+	log.Warn("state already locked, retry later", "name", name, "retryAfter", after)
+	w.Header().Set("Retry-After", strconv.Itoa(after))
+	http.Error(w, "Too Many Requests: state locked", http.StatusTooManyRequests)
+}
+
+// requestLockID retrieves the lock ID sent with the request.
+// The ID of the lock info in the body takes precedence over the X-Terraform-Lock-ID header.
+func requestLockID(r *http.Request, body []byte) string {
+	if info, err := parseLockInfo(body); err == nil && info.ID != "" {
+		return info.ID
+	}
+
+	return r.Header.Get(lockIDHeader)
+}
+
+// checkLockID reports whether the lock ID matches the lock held on the state.
+// A lock held with an ID is only matched by that ID, requests without one included.
+// Unlocked states and locks without an ID are matched by any ID.
+func (s *Storage) checkLockID(name, id string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(s.path, name+lockFileExt))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		}
+
+		return false, fmt.Errorf("failed to read lock file of %s: %w", name, err)
+	}
+
+	info, err := parseLockInfo(data)
+	if err != nil || info.ID == "" {
+		return true, nil //nolint:nilerr // a lock with unparsable info is held by an unknown ID
+	}
+
+	return info.ID == id, nil
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStorageHandleUnlockLockID(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		body   string
+		header string
+		want   int
+	}{
+		{"no ID", "", "", http.StatusConflict},
+		{"body", `{"ID":"held"}`, "", http.StatusOK},
+		{"wrong body", `{"ID":"other"}`, "", http.StatusConflict},
+		{"header", "", "held", http.StatusOK},
+		{"wrong header", "", "other", http.StatusConflict},
+		{"body over header", `{"ID":"held"}`, "other", http.StatusOK},
+		{"wrong body over header", `{"ID":"other"}`, "held", http.StatusConflict},
+		{"body without ID", `{"Who":"someone"}`, "held", http.StatusOK},
+		{"body without ID nor header", `{"Who":"someone"}`, "", http.StatusConflict},
+	}
+
+	for _, tc := range testCases {
+		storage := setupTestStorage(t)
+
+		lockFile := filepath.Join(storage.path, name+lockFileExt)
+		if err := os.WriteFile(lockFile, []byte(`{"ID":"held"}`), defaultFileMode); err != nil {
+			t.Fatalf("failed to write lock file: %v", err)
+		}
+
+		req := httptest.NewRequest("UNLOCK", "/"+name, bytes.NewBufferString(tc.body))
+		if tc.header != "" {
+			req.Header.Set(lockIDHeader, tc.header)
+		}
+
+		w := httptest.NewRecorder()
+		storage.handleUnlock(w, req, name)
+
+		if w.Code != tc.want {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", tc.name, w.Code, tc.want)
+		}
+
+		if locked, _ := storage.isLocked(name); locked != (tc.want != http.StatusOK) {
+			t.Fatalf("unexpected lock state for %s: %v", tc.name, locked)
+		}
+	}
+}
+
+func TestStorageHandlePostLockID(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	lockFile := filepath.Join(storage.path, name+lockFileExt)
+	if err := os.WriteFile(lockFile, []byte(`{"ID":"held"}`), defaultFileMode); err != nil {
+		t.Fatalf("failed to write lock file: %v", err)
+	}
+
+	testCases := []struct {
+		target string
+		header string
+		want   int
+	}{
+		{"/" + name + "?ID=other", "", http.StatusLocked},
+		{"/" + name, "other", http.StatusLocked},
+		{"/" + name + "?ID=held", "other", http.StatusCreated},
+		{"/" + name, "", http.StatusLocked},
+		{"/" + name, "held", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodPost, tc.target, bytes.NewBufferString("content"))
+		if tc.header != "" {
+			req.Header.Set(lockIDHeader, tc.header)
+		}
+
+		req.SetPathValue("name", name)
+
+		w := httptest.NewRecorder()
+		storage.handleState(w, req)
+
+		if w.Code != tc.want {
+			t.Fatalf("unexpected status code for %s with header %q: got %d, want %d", tc.target, tc.header, w.Code, tc.want)
+		}
+	}
+
+	// A lock acquired without an ID can't be checked.
+	if err := os.WriteFile(lockFile, []byte(`{"Who":"someone"}`), defaultFileMode); err != nil {
+		t.Fatalf("failed to write lock file: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/"+name, bytes.NewBufferString("content"))
+	req.SetPathValue("name", name)
+
+	w := httptest.NewRecorder()
+	storage.handleState(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code for lock without ID: got %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestStorageHandleLockSameID(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	lockFile := filepath.Join(storage.path, name+lockFileExt)
+	if err := os.WriteFile(lockFile, []byte(`{"ID":"held","Who":"first"}`), defaultFileMode); err != nil {
+		t.Fatalf("failed to write lock file: %v", err)
+	}
+
+	testCases := []struct {
+		name   string
+		body   string
+		header string
+		want   int
+	}{
+		{"same body", `{"ID":"held","Who":"retry"}`, "", http.StatusOK},
+		{"same header", "", "held", http.StatusOK},
+		{"other body", `{"ID":"other"}`, "", http.StatusLocked},
+		{"other header", "", "other", http.StatusLocked},
+		{"no ID", "", "", http.StatusLocked},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest("LOCK", "/"+name, bytes.NewBufferString(tc.body))
+		if tc.header != "" {
+			req.Header.Set(lockIDHeader, tc.header)
+		}
+
+		w := httptest.NewRecorder()
+		storage.handleLock(w, req, name)
+
+		if w.Code != tc.want {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", tc.name, w.Code, tc.want)
+		}
+
+		if tc.want == http.StatusOK && w.Body.String() != `{"ID":"held","Who":"first"}` {
+			t.Fatalf("unexpected lock info for %s: %s", tc.name, w.Body.String())
+		}
+	}
+}
+
+func TestStorageLockMaxLifetime(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.lockMaxLifetime = time.Hour
+	lockPath := filepath.Join(storage.path, name+lockFileExt)
+
+	lock := func() int {
+		req := httptest.NewRequest("LOCK", "/"+name, bytes.NewBufferString(`{"ID":"new"}`))
+		req.SetPathValue("name", name)
+
+		w := httptest.NewRecorder()
+		storage.handleState(w, req)
+
+		return w.Code
+	}
+
+	if err := os.WriteFile(lockPath, []byte(`{"ID":"old"}`), defaultFileMode); err != nil {
+		t.Fatalf("failed to write lock file: %v", err)
+	}
+
+	if code := lock(); code != http.StatusLocked {
+		t.Fatalf("unexpected status code for young lock: got %d, want %d", code, http.StatusLocked)
+	}
+
+	acquired := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(lockPath, acquired, acquired); err != nil {
+		t.Fatalf("failed to age lock file: %v", err)
+	}
+
+	if code := lock(); code != http.StatusOK {
+		t.Fatalf("unexpected status code for expired lock: got %d, want %d", code, http.StatusOK)
+	}
+
+	if match, err := storage.checkLockID(name, "new"); err != nil || !match {
+		t.Fatalf("lock not replaced: match %v, error %v", match, err)
+	}
+}
+
+func TestStorageLockMaxLifetimeAcquired(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.lockMaxLifetime = time.Hour
+	lockPath := filepath.Join(storage.path, name+lockFileExt)
+
+	// The stored acquisition time counts, not the time the lock file was last written.
+	old := stampLock([]byte(`{"ID":"old"}`), time.Now().Add(-2*time.Hour))
+	writeTestFile(t, lockPath, string(old))
+
+	if err := storage.expireLock(name); err != nil {
+		t.Fatalf("failed to expire lock: %v", err)
+	}
+
+	if locked, _ := storage.isLocked(name); locked {
+		t.Fatal("lock acquired long ago not expired")
+	}
+
+	writeTestFile(t, lockPath, string(stampLock([]byte(`{"ID":"young"}`), time.Now())))
+
+	aged := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(lockPath, aged, aged); err != nil {
+		t.Fatalf("failed to age lock file: %v", err)
+	}
+
+	if err := storage.expireLock(name); err != nil {
+		t.Fatalf("failed to expire lock: %v", err)
+	}
+
+	if locked, _ := storage.isLocked(name); !locked {
+		t.Fatal("lock acquired recently expired")
+	}
+}
+
+func TestStorageMaxConcurrentLocksExpired(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.lockMaxLifetime = time.Hour
+	storage.maxLocks = 1
+
+	old := stampLock([]byte(`{"ID":"old"}`), time.Now().Add(-2*time.Hour))
+	writeTestFile(t, filepath.Join(storage.path, "other"+lockFileExt), string(old))
+
+	w := httptest.NewRecorder()
+	storage.handleLock(w, httptest.NewRequest("LOCK", "/"+name, bytes.NewBufferString(`{"ID":"new"}`)), name)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if locked, _ := storage.isLocked("other"); locked {
+		t.Fatal("expired lock not swept")
+	}
+}
+
+func TestStorageLockRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.lockRetryAfter = 10 * time.Second
+
+	if err := os.WriteFile(filepath.Join(storage.path, name+lockFileExt), nil, defaultFileMode); err != nil {
+		t.Fatalf("failed to write lock file: %v", err)
+	}
+
+	seen := map[int]bool{}
+
+	for range 50 {
+		w := httptest.NewRecorder()
+		storage.handleLock(w, httptest.NewRequest("LOCK", "/"+name, nil), name)
+
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusTooManyRequests)
+		}
+
+		after, err := strconv.Atoi(w.Header().Get("Retry-After"))
+		if err != nil || after < 10 || after > 20 {
+			t.Fatalf("Retry-After out of range: %q", w.Header().Get("Retry-After"))
+		}
+
+		seen[after] = true
+	}
+
+	if len(seen) < 2 {
+		t.Fatalf("Retry-After not jittered: %v", seen)
+	}
+
+	storage.lockRetryAfter = 0
+
+	w := httptest.NewRecorder()
+	storage.handleLock(w, httptest.NewRequest("LOCK", "/"+name, nil), name)
+
+	if w.Code != http.StatusLocked || w.Header().Get("Retry-After") != "" {
+		t.Fatalf("unexpected response without retry: %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestStorageHandleGetLock(t *testing.T) {
+	t.Parallel()
+
+	get := func(storage *Storage) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/"+name+"/lock", nil)
+		req.SetPathValue("name", name)
+		req.SetPathValue("action", "lock")
+
+		w := httptest.NewRecorder()
+		storage.handleAction(w, req)
+
+		return w
+	}
+
+	storage := setupTestStorage(t)
+	storage.debug = true
+
+	if w := get(storage); w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code when unlocked: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	info := `{"ID":"held","Who":"someone"}`
+	if err := os.WriteFile(filepath.Join(storage.path, name+lockFileExt), []byte(info), defaultFileMode); err != nil {
+		t.Fatalf("failed to write lock file: %v", err)
+	}
+
+	w := get(storage)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code when locked: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if w.Body.String() != info {
+		t.Fatalf("unexpected lock info: got %s, want %s", w.Body.String(), info)
+	}
+
+	storage.debug = false
+
+	if w := get(storage); w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code without debug: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestStorageMaxConcurrentLocks(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.maxLocks = 2
+
+	lock := func(n, id string) int {
+		w := httptest.NewRecorder()
+		storage.handleLock(w, httptest.NewRequest("LOCK", "/"+n, bytes.NewBufferString(`{"ID":"`+id+`"}`)), n)
+
+		return w.Code
+	}
+
+	for _, tc := range []struct {
+		name string
+		id   string
+		want int
+	}{
+		{"a", "1", http.StatusOK},
+		{"b", "2", http.StatusOK},
+		{"c", "3", http.StatusServiceUnavailable},
+		{"a", "1", http.StatusOK},
+		{"a", "other", http.StatusLocked},
+	} {
+		if code := lock(tc.name, tc.id); code != tc.want {
+			t.Fatalf("unexpected status code locking %s with %s: got %d, want %d", tc.name, tc.id, code, tc.want)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(storage.path, "b"+lockFileExt)); err != nil {
+		t.Fatalf("failed to remove lock: %v", err)
+	}
+
+	if code := lock("c", "3"); code != http.StatusOK {
+		t.Fatalf("unexpected status code once a lock is released: got %d, want %d", code, http.StatusOK)
+	}
+}
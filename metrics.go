@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	log "log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8" // Prometheus text exposition format.
+	overflowLabelValue = "_other"                                   // Label value of values over the limit.
+
+	defaultMetricsMaxStates = 100 // Default number of states with their own label in per state metrics.
+)
+
+// escapeLabelValue escapes a label value for the Prometheus text format.
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// Metric types.
+const (
+	metricCounter = "counter"
+	metricGauge   = "gauge"
+)
+
+// sample represents a single value of a metric family.
+type sample struct {
+	name       string  // Metric name.
+	kind       string  // Metric type, counter or gauge.
+	label      string  // Label name, empty if the metric has no label.
+	labelValue string  // Label value.
+	value      float64 // Current value.
+}
+
+// metric is a metric family that can be written in the Prometheus text format
+// and exported as samples to push based collectors.
+type metric interface {
+	write(w io.Writer) error
+	samples() []sample
+}
+
+// counterVec represents a counter partitioned by a single label.
+type counterVec struct {
+	name  string // Metric name.
+	help  string // Metric description.
+	label string // Label name.
+	limit int    // Maximum number of label values, further ones are counted as overflowLabelValue. 0 is unlimited.
+
+	mu     sync.Mutex
+	values map[string]uint64 // Counter values by label value.
+}
+
+// newCounterVec retrieves a counter with the given label values initialized to zero.
+func newCounterVec(name, help, label string, values ...string) *counterVec {
+	c := &counterVec{name: name, help: help, label: label, values: make(map[string]uint64)}
+
+	for _, v := range values {
+		c.values[v] = 0
+	}
+
+	return c
+}
+
+// inc increments the counter for the label value.
+// Once the limit of label values is reached, new values are counted as overflowLabelValue,
+// so label values coming from clients can't grow the exposition without bound.
+func (c *counterVec) inc(value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.values[value]; !ok && c.limit > 0 && len(c.values) >= c.limit {
+		value = overflowLabelValue
+	}
+
+	c.values[value]++
+}
+
+// get returns the counter for the label value.
+func (c *counterVec) get(value string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[value]
+}
+
+func (c *counterVec) samples() []sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	samples := make([]sample, 0, len(c.values))
+
+	for k, v := range c.values {
+		samples = append(samples, sample{name: c.name, kind: metricCounter, label: c.label, labelValue: k, value: float64(v)})
+	}
+
+	return samples
+}
+
+func (c *counterVec) write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name); err != nil {
+		return fmt.Errorf("failed to write %s: %w", c.name, err)
+	}
+
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		_, err := fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", c.name, c.label, escapeLabelValue(k), c.values[k])
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", c.name, err)
+		}
+	}
+
+	return nil
+}
+
+// counter represents a counter without labels.
+type counter struct {
+	name string // Metric name.
+	help string // Metric description.
+
+	value atomic.Uint64
+}
+
+// inc increments the counter.
+func (c *counter) inc() {
+	c.value.Add(1)
+}
+
+func (c *counter) samples() []sample {
+	return []sample{{name: c.name, kind: metricCounter, value: float64(c.value.Load())}}
+}
+
+func (c *counter) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.value.Load())
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", c.name, err)
+	}
+
+	return nil
+}
+
+// gaugeFunc represents a gauge whose value is retrieved on every scrape.
+type gaugeFunc struct {
+	name  string                  // Metric name.
+	help  string                  // Metric description.
+	value func() (float64, error) // Retrieves the current value.
+}
+
+func (g *gaugeFunc) samples() []sample {
+	v, err := g.value()
+	if err != nil {
+		return nil
+	}
+
+	return []sample{{name: g.name, kind: metricGauge, value: v}}
+}
+
+func (g *gaugeFunc) write(w io.Writer) error {
+	v, err := g.value()
+	if err != nil {
+		log.Debug("gauge value not available", "name", g.name, "error", err)
+
+		return nil
+	}
+
+	_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", g.name, g.help, g.name, g.name, v)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", g.name, err)
+	}
+
+	return nil
+}
+
+// Metrics represents the metrics exposed by the backend.
+type Metrics struct {
+	rejectedMethods *counterVec  // Requests rejected with 405.
+	writeTimeouts   *counter     // POSTs aborted as the client was too slow to send the state.
+	stateWrites     *counterVec  // Successful POSTs by state name.
+	freeSpace       *gaugeFunc   // Free space on the storage file system, if set.
+	oldestState     *gaugeFunc   // Age of the least recently modified state, if set.
+	connsPerIP      *connLimiter // Connections per source IP, if limited.
+}
+
+// NewMetrics retrieves new Metrics instance.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		rejectedMethods: newCounterVec(
+			"terraform_backend_rejected_method_total",
+			"Number of state requests rejected with an unknown method.",
+			"method",
+			"LOCK", "UNLOCK", "other",
+		),
+		writeTimeouts: &counter{
+			name: "terraform_backend_write_timeout_total",
+			help: "Number of state writes aborted as the client was too slow to send the state.",
+		},
+		stateWrites: &counterVec{
+			name:   "terraform_backend_writes_total",
+			help:   "Number of successful state writes by state name.",
+			label:  "name",
+			limit:  defaultMetricsMaxStates,
+			values: make(map[string]uint64),
+		},
+	}
+}
+
+// all returns every metric family in exposition order.
+func (m *Metrics) all() []metric {
+	all := []metric{m.rejectedMethods, m.writeTimeouts, m.stateWrites}
+
+	if m.freeSpace != nil {
+		all = append(all, m.freeSpace)
+	}
+
+	if m.oldestState != nil {
+		all = append(all, m.oldestState)
+	}
+
+	if m.connsPerIP != nil {
+		all = append(all, m.connsPerIP)
+	}
+
+	return all
+}
+
+// handleMetrics is an HTTP handler that exposes metrics in the Prometheus text format.
+func (m *Metrics) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+
+	for _, mf := range m.all() {
+		if err := mf.write(w); err != nil {
+			log.Error("failed to write metrics", "error", err)
+
+			return
+		}
+	}
+}
+
+// methodLabel buckets a rejected method into LOCK, UNLOCK or other.
+// Methods differing only in case are counted as LOCK and UNLOCK.
+func methodLabel(method string) string {
+	switch strings.ToUpper(method) {
+	case "LOCK":
+		return "LOCK"
+	case "UNLOCK":
+		return "UNLOCK"
+	default:
+		return "other"
+	}
+}
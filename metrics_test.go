@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStorageRejectedMethodMetric(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	for _, method := range []string{"lock", "PUT", "PATCH"} {
+		req := httptest.NewRequest(method, "/test", nil)
+		req.SetPathValue("name", name)
+
+		w := httptest.NewRecorder()
+
+		storage.handleState(w, req)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", method, w.Code, http.StatusMethodNotAllowed)
+		}
+	}
+
+	w := httptest.NewRecorder()
+
+	storage.metrics.handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %v", err)
+	}
+
+	for _, line := range []string{
+		"# TYPE terraform_backend_rejected_method_total counter",
+		`terraform_backend_rejected_method_total{method="LOCK"} 1`,
+		`terraform_backend_rejected_method_total{method="UNLOCK"} 0`,
+		`terraform_backend_rejected_method_total{method="other"} 2`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Fatalf("metrics output does not contain %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestStorageStateWritesMetric(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.metrics.stateWrites.limit = 2
+
+	for _, n := range []string{"a", "a", "b", "c", "d"} {
+		w := httptest.NewRecorder()
+		storage.handlePost(w, httptest.NewRequest(http.MethodPost, "/"+n, strings.NewReader("content")), n)
+
+		if w.Code != http.StatusCreated && w.Code != http.StatusOK {
+			t.Fatalf("unexpected status code for %s: got %d", n, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	storage.handlePost(w, httptest.NewRequest(http.MethodPost, "/a?ID=other", strings.NewReader("")), "a")
+
+	w = httptest.NewRecorder()
+	storage.metrics.handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	for _, line := range []string{
+		`terraform_backend_writes_total{name="_other"} 2`,
+		`terraform_backend_writes_total{name="a"} 2`,
+		`terraform_backend_writes_total{name="b"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Fatalf("metrics output does not contain %q:\n%s", line, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidPointer = errors.New("invalid JSON pointer")
+	ErrNoValue        = errors.New("no value at JSON pointer")
+)
+
+// resolvePointer retrieves the value the RFC 6901 JSON pointer refers to in the document.
+func resolvePointer(doc any, pointer string) (any, error) {
+	if pointer == "" {
+		return doc, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPointer, pointer)
+	}
+
+	value := doc
+
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+
+		switch v := value.(type) {
+		case map[string]any:
+			next, ok := v[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: %q", ErrNoValue, pointer)
+			}
+
+			value = next
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) || (token != "0" && strings.HasPrefix(token, "0")) {
+				return nil, fmt.Errorf("%w: %q", ErrNoValue, pointer)
+			}
+
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrNoValue, pointer)
+		}
+	}
+
+	return value, nil
+}
+
+// writeQuery writes the part of the state the JSON pointer refers to.
+// It's only used when a client explicitly asks for it with the query parameter,
+// the full state served to Terraform is never transformed.
+func writeQuery(w http.ResponseWriter, data []byte, pointer, name string) {
+	var doc any
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if err := dec.Decode(&doc); err != nil {
+		log.Warn("query on non-JSON state", "name", name, "error", err)
+		http.Error(w, "Unprocessable Entity: state is not JSON", http.StatusUnprocessableEntity)
+
+		return
+	}
+
+	value, err := resolvePointer(doc, pointer)
+	if err != nil {
+		if errors.Is(err, ErrInvalidPointer) {
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		http.Error(w, "Not Found: "+err.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Error("failed to encode JSON:", "name", name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStorageHandleGetQuery(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	content := `{"version":4,"resources":[{"type":"aws_instance","name":"web","a/b":{"m~n":1}}]}`
+
+	for state, data := range map[string]string{name: content, "plain": "not json"} {
+		if err := os.WriteFile(filepath.Join(storage.path, state+stateFileExt), []byte(data), defaultFileMode); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		state string
+		query string
+		code  int
+		body  string
+	}{
+		{name, "/resources/0/type", http.StatusOK, `"aws_instance"`},
+		{name, "/version", http.StatusOK, "4"},
+		{name, "/resources/0/a~1b/m~0n", http.StatusOK, "1"},
+		{name, "/resources/1", http.StatusNotFound, ""},
+		{name, "/resources/01", http.StatusNotFound, ""},
+		{name, "resources", http.StatusBadRequest, ""},
+		{"plain", "/version", http.StatusUnprocessableEntity, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/"+tc.state+"?query="+url.QueryEscape(tc.query), nil)
+		w := httptest.NewRecorder()
+
+		storage.handleGet(w, req, tc.state)
+
+		if w.Code != tc.code {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", tc.query, w.Code, tc.code)
+		}
+
+		if tc.body != "" && strings.TrimSpace(w.Body.String()) != tc.body {
+			t.Fatalf("unexpected body for %s: got %s, want %s", tc.query, w.Body.String(), tc.body)
+		}
+	}
+}
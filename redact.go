@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	redactedHashLen = 12 // Number of hex digits of the hash replacing a redacted match.
+	redactKeyLen    = 32 // Size of the key the hashes are made with in bytes.
+)
+
+// nameRedactor masks the parts of state names matching a pattern in logs and metrics.
+// Each match is replaced with a keyed hash of it, so entries about the same state still correlate
+// while short names such as customer IDs can't be recovered by hashing candidates.
+// The key is random, so hashes are only stable for the lifetime of the server.
+type nameRedactor struct {
+	pattern *regexp.Regexp
+	key     []byte
+}
+
+// newNameRedactor retrieves a redactor masking the matches of the pattern.
+// Returns nil if the pattern is empty, which redacts nothing.
+func newNameRedactor(pattern string) (*nameRedactor, error) {
+	re, err := compilePattern(pattern)
+	if re == nil || err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, redactKeyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate redaction key: %w", err)
+	}
+
+	return &nameRedactor{pattern: re, key: key}, nil
+}
+
+// redactedValue returns the replacement of a redacted match.
+func (nr *nameRedactor) redactedValue(match string) string {
+	mac := hmac.New(sha256.New, nr.key)
+	mac.Write([]byte(match))
+
+	return "[redacted:" + hex.EncodeToString(mac.Sum(nil))[:redactedHashLen] + "]"
+}
+
+// redact returns the state name with the matches of the pattern masked.
+// The pattern is matched against the name alone, so it may be anchored.
+func (nr *nameRedactor) redact(name string) string {
+	if nr == nil {
+		return name
+	}
+
+	return nr.pattern.ReplaceAllStringFunc(name, nr.redactedValue)
+}
+
+// redactFile returns the file name with the state name it is made of masked.
+func (nr *nameRedactor) redactFile(file string) string {
+	ext := filepath.Ext(file)
+
+	return nr.redact(strings.TrimSuffix(file, ext)) + ext
+}
+
+// redactPath returns the path with each of its segments masked as a state name.
+func (nr *nameRedactor) redactPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = nr.redactFile(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// redactURI returns the request URI with the state names of the path and of the to parameter masked.
+func (nr *nameRedactor) redactURI(r *http.Request) string {
+	if nr == nil {
+		return r.RequestURI
+	}
+
+	uri := nr.redactPath(r.URL.Path)
+	if r.URL.RawQuery == "" {
+		return uri
+	}
+
+	params := strings.Split(r.URL.RawQuery, "&")
+	for i, param := range params {
+		key, value, _ := strings.Cut(param, "=")
+		if v, err := url.QueryUnescape(value); key == "to" && err == nil {
+			params[i] = key + "=" + nr.redact(v)
+		}
+	}
+
+	return uri + "?" + strings.Join(params, "&")
+}
+
+// Log attributes carrying state names.
+const (
+	nameAttr = "name" // Name of the state a message is about.
+	toAttr   = "to"   // Name of the state moved or copied to.
+	pathAttr = "path" // Request or file path made of state names.
+	fileAttr = "file" // File name made of a state name.
+)
+
+// redactHandler wraps a log handler to mask state names in the attributes carrying them.
+// Other string attributes, such as errors, have the names of the same entry masked where they quote them.
+type redactHandler struct {
+	next log.Handler
+	nr   *nameRedactor
+}
+
+// handler wraps h to mask state names, h is returned as is if nothing is redacted.
+func (nr *nameRedactor) handler(h log.Handler) log.Handler {
+	if nr == nil {
+		return h
+	}
+
+	return &redactHandler{next: h, nr: nr}
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level log.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// redactAttr masks the state names of the attribute, quoted names are replaced with the replacer.
+func (h *redactHandler) redactAttr(a log.Attr, quoted *strings.Replacer) log.Attr {
+	switch a.Key {
+	case nameAttr, toAttr:
+		return log.String(a.Key, h.nr.redact(a.Value.String()))
+	case pathAttr:
+		return log.String(a.Key, h.nr.redactPath(a.Value.String()))
+	case fileAttr:
+		return log.String(a.Key, h.nr.redactFile(a.Value.String()))
+	}
+
+	if quoted == nil {
+		return a
+	}
+
+	switch v := a.Value.Resolve(); {
+	case v.Kind() == log.KindString:
+		return log.String(a.Key, quoted.Replace(v.String()))
+	case v.Kind() == log.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return log.String(a.Key, quoted.Replace(err.Error()))
+		}
+	}
+
+	return a
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r log.Record) error {
+	var names []string
+
+	r.Attrs(func(a log.Attr) bool {
+		if a.Key == nameAttr || a.Key == toAttr {
+			if name := a.Value.String(); name != "" {
+				names = append(names, name, h.nr.redact(name))
+			}
+		}
+
+		return true
+	})
+
+	var quoted *strings.Replacer
+	if len(names) > 0 {
+		quoted = strings.NewReplacer(names...)
+	}
+
+	redacted := log.NewRecord(r.Time, r.Level, r.Message, r.PC)
+
+	r.Attrs(func(a log.Attr) bool {
+		redacted.AddAttrs(h.redactAttr(a, quoted))
+
+		return true
+	})
+
+	return h.next.Handle(ctx, redacted) //nolint:wrapcheck // the error is the one of the wrapped handler
+}
+
+func (h *redactHandler) WithAttrs(attrs []log.Attr) log.Handler {
+	redacted := make([]log.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redactAttr(a, nil)
+	}
+
+	return &redactHandler{next: h.next.WithAttrs(redacted), nr: h.nr}
+}
+
+func (h *redactHandler) WithGroup(name string) log.Handler {
+	return &redactHandler{next: h.next.WithGroup(name), nr: h.nr}
+}
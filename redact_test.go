@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewNameRedactor(t *testing.T) {
+	t.Parallel()
+
+	if nr, err := newNameRedactor(""); nr != nil || err != nil {
+		t.Fatalf("unexpected redactor for empty pattern: %v, %v", nr, err)
+	}
+
+	if _, err := newNameRedactor("cust-[0-9"); err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+
+	var nr *nameRedactor
+	if got := nr.redact("cust-42"); got != "cust-42" {
+		t.Fatalf("unexpected redaction without pattern: %s", got)
+	}
+}
+
+func TestNameRedactorLogs(t *testing.T) {
+	t.Parallel()
+
+	nr, err := newNameRedactor(`^cust-[0-9]+`)
+	if err != nil {
+		t.Fatalf("failed to create redactor: %v", err)
+	}
+
+	var out bytes.Buffer
+
+	handler, err := newLogHandler(logFormatJSON, slog.LevelInfo, &out)
+	if err != nil {
+		t.Fatalf("failed to create log handler: %v", err)
+	}
+
+	logger := slog.New(nr.handler(handler))
+	logger.Warn("state locked", "name", "cust-42-prod", "error", errors.New("failed to read cust-42-prod"))
+	logger.Info("audit", "path", "/cust-42-prod/move", "to", "cust-43")
+	logger.Info("other", "name", "shared-cust-44")
+
+	masked := nr.redact("cust-42")
+
+	if !strings.HasPrefix(masked, "[redacted:") || masked != nr.redact("cust-42") || masked == nr.redact("cust-43") {
+		t.Fatalf("unexpected redacted value: %s", masked)
+	}
+
+	for _, v := range []string{"cust-42", "cust-43"} {
+		if strings.Contains(out.String(), v) {
+			t.Fatalf("log output contains the redacted name %s:\n%s", v, out.String())
+		}
+	}
+
+	for _, v := range []string{masked + "-prod", "/" + masked + "-prod/move", nr.redact("cust-43"), "shared-cust-44"} {
+		if !strings.Contains(out.String(), v) {
+			t.Fatalf("log output does not contain %q:\n%s", v, out.String())
+		}
+	}
+}
+
+func TestNameRedactorLeavesOtherAttrs(t *testing.T) {
+	t.Parallel()
+
+	nr, err := newNameRedactor(`[0-9]{3,}`)
+	if err != nil {
+		t.Fatalf("failed to create redactor: %v", err)
+	}
+
+	var out bytes.Buffer
+
+	handler, err := newLogHandler(logFormatJSON, slog.LevelInfo, &out)
+	if err != nil {
+		t.Fatalf("failed to create log handler: %v", err)
+	}
+
+	slog.New(nr.handler(handler)).Error("request failed", "name", "team-1234", "status", 500)
+
+	var entry map[string]any
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to decode log entry: %v", err)
+	}
+
+	if _, err := time.Parse(time.RFC3339Nano, entry["time"].(string)); err != nil || entry["status"] != float64(500) {
+		t.Fatalf("attributes other than names redacted: %s", out.String())
+	}
+
+	if entry["name"] != "team-"+nr.redact("1234") {
+		t.Fatalf("unexpected name: %v", entry["name"])
+	}
+}
+
+func TestNameRedactorAccessLogAndMetrics(t *testing.T) {
+	t.Parallel()
+
+	nr, err := newNameRedactor(`^cust-[0-9]+$`)
+	if err != nil {
+		t.Fatalf("failed to create redactor: %v", err)
+	}
+
+	storage := setupTestStorage(t)
+	storage.redactor = nr
+
+	mux := &routeMux{ServeMux: http.NewServeMux()}
+	storage.registerRoutes(mux)
+
+	var out bytes.Buffer
+
+	handler, err := accessLog(accessLogCommon, &out, nr, mux)
+	if err != nil {
+		t.Fatalf("failed to create access log: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/cust-42", strings.NewReader("content")))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusCreated)
+	}
+
+	if exists, err := storage.exists("cust-42"); !exists || err != nil {
+		t.Fatalf("state not stored under its real name: %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/cust-42/copy?to=cust-43", nil))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected status code for copy: got %d, want %d", w.Code, http.StatusCreated)
+	}
+
+	if strings.Contains(out.String(), "cust-43") || !strings.Contains(out.String(), "?to="+nr.redact("cust-43")) {
+		t.Fatalf("copy target not redacted:\n%s", out.String())
+	}
+
+	w = httptest.NewRecorder()
+	storage.metrics.handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	for name, body := range map[string]string{"access log": out.String(), "metrics": w.Body.String()} {
+		if strings.Contains(body, "cust-42") || !strings.Contains(body, nr.redact("cust-42")) {
+			t.Fatalf("%s not redacted:\n%s", name, body)
+		}
+	}
+}
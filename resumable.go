@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	log "log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	partFileExt        = ".part"       // Partial upload file extension.
+	resumableUploadTTL = 1 * time.Hour // Time after which an idle partial upload is discarded.
+)
+
+var (
+	ErrInvalidContentRange = errors.New("invalid Content-Range")
+	ErrIncompleteChunk     = errors.New("incomplete chunk")
+)
+
+// upload represents a resumable upload in progress.
+// Chunks are appended in order to a partial file which replaces the state once complete.
+// The fields after mu are guarded by it, so receiving a chunk only holds up chunks of the same upload.
+type upload struct {
+	file  string // Path to the partial file.
+	total int64  // Size of the complete state.
+
+	mu        sync.Mutex
+	received  int64     // Number of bytes received so far.
+	updated   time.Time // Time of the last received chunk.
+	discarded bool      // Set once the upload is expired, restarted or committed.
+}
+
+// contentRange represents a parsed Content-Range header.
+type contentRange struct {
+	first int64 // Offset of the first byte of the chunk.
+	last  int64 // Offset of the last byte of the chunk.
+	total int64 // Size of the complete state.
+}
+
+// size returns the chunk size in bytes.
+func (c contentRange) size() int64 {
+	return c.last - c.first + 1
+}
+
+// parseContentRange parses the `bytes <first>-<last>/<total>` header value.
+func parseContentRange(value string) (contentRange, error) {
+	var c contentRange
+
+	spec, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return c, fmt.Errorf("%w: %q", ErrInvalidContentRange, value)
+	}
+
+	rng, total, _ := strings.Cut(spec, "/")
+	first, last, _ := strings.Cut(rng, "-")
+
+	var err error
+
+	for _, v := range []struct {
+		dst *int64
+		src string
+	}{{&c.first, first}, {&c.last, last}, {&c.total, total}} {
+		if *v.dst, err = strconv.ParseInt(v.src, 10, 64); err != nil {
+			return c, fmt.Errorf("%w: %q", ErrInvalidContentRange, value)
+		}
+	}
+
+	if c.first < 0 || c.last < c.first || c.last >= c.total {
+		return c, fmt.Errorf("%w: %q", ErrInvalidContentRange, value)
+	}
+
+	return c, nil
+}
+
+// setReceivedRange reports the range of bytes received so far.
+func setReceivedRange(w http.ResponseWriter, received int64) {
+	if received > 0 {
+		w.Header().Set("Range", "bytes=0-"+strconv.FormatInt(received-1, 10))
+	}
+}
+
+// expireUploads discards partial uploads idle for longer than resumableUploadTTL.
+// Uploads receiving a chunk are not idle and left alone.
+// The caller must hold s.mu.
+func (s *Storage) expireUploads(now time.Time) {
+	for name, u := range s.uploads {
+		if !u.mu.TryLock() {
+			continue
+		}
+
+		if now.Sub(u.updated) < resumableUploadTTL {
+			u.mu.Unlock()
+
+			continue
+		}
+
+		u.discarded = true
+		u.mu.Unlock()
+
+		log.Info("resumable upload expired", "name", name, "received", u.received, "total", u.total)
+
+		if err := os.Remove(u.file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Error("failed to remove partial upload", "name", name, "error", err)
+		}
+
+		delete(s.uploads, name)
+	}
+}
+
+// startUpload begins a new resumable upload, discarding a previous one for the same name.
+// A previous upload still receiving a chunk is not discarded, ErrConflict is returned instead.
+// The caller must hold s.mu.
+func (s *Storage) startUpload(name string, total int64) (*upload, error) {
+	if u, ok := s.uploads[name]; ok {
+		if !u.mu.TryLock() {
+			return nil, fmt.Errorf("%w: upload of %s receiving a chunk", ErrConflict, name)
+		}
+
+		log.Debug("restarting resumable upload", "name", name)
+
+		u.discarded = true
+		u.mu.Unlock()
+
+		os.Remove(u.file)
+		delete(s.uploads, name)
+	}
+
+	f, err := os.CreateTemp(s.path, name+"-*"+partFileExt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create partial file for %s: %w", name, err)
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+
+		return nil, fmt.Errorf("failed to close partial file for %s: %w", name, err)
+	}
+
+	u := &upload{file: f.Name(), total: total, updated: time.Now()}
+	s.uploads[name] = u
+
+	return u, nil
+}
+
+// append writes n bytes from r at the end of the partial file.
+// A chunk that can't be received completely is discarded.
+// The partial file is returned open when the upload is complete.
+// The caller must hold u.mu.
+func (u *upload) append(r io.Reader, n int64) (*os.File, error) {
+	f, err := os.OpenFile(u.file, os.O_WRONLY|os.O_APPEND, defaultFileMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open partial file: %w", err)
+	}
+
+	written, err := io.CopyN(f, r, n)
+	if err != nil {
+		if truncErr := f.Truncate(u.received); truncErr != nil {
+			err = errors.Join(err, truncErr)
+		}
+
+		f.Close()
+
+		return nil, fmt.Errorf("%w: received %d of %d bytes: %w", ErrIncompleteChunk, written, n, err)
+	}
+
+	u.received += written
+	u.updated = time.Now()
+
+	if u.received < u.total {
+		return nil, f.Close()
+	}
+
+	return f, nil
+}
+
+// handleChunk is HTTP handler for POST method carrying a chunk of a resumable upload.
+// Incomplete uploads are acknowledged with 202 and the Range of bytes received so far.
+// A chunk not continuing the upload is rejected with 416 and the same Range.
+func (s *Storage) handleChunk(w http.ResponseWriter, r *http.Request, name string) {
+	cr, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err == nil && r.ContentLength >= 0 && r.ContentLength != cr.size() {
+		err = fmt.Errorf("%w: body length %d does not match range", ErrInvalidContentRange, r.ContentLength)
+	}
+
+	if err != nil {
+		log.Warn("invalid chunk", "name", name, "error", err)
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if s.maxStateSize > 0 && cr.total > s.maxStateSize {
+		log.Warn("state too large", "name", name, "size", cr.total, "limit", s.maxStateSize)
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	u, err := s.chunkUpload(name, cr)
+	if err != nil {
+		writeError(w, "failed to start resumable upload", name, err)
+
+		return
+	}
+
+	if u != nil {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+	}
+
+	if u == nil || u.discarded || cr.first != u.received || cr.total != u.total {
+		if u != nil && !u.discarded {
+			setReceivedRange(w, u.received)
+		}
+
+		http.Error(w, "Requested Range Not Satisfiable", http.StatusRequestedRangeNotSatisfiable)
+
+		return
+	}
+
+	f, err := u.append(r.Body, cr.size())
+	if err != nil {
+		log.Error("failed to receive chunk", "name", name, "error", err)
+		setReceivedRange(w, u.received)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+
+		return
+	}
+
+	setReceivedRange(w, u.received)
+
+	if f == nil {
+		w.WriteHeader(http.StatusAccepted)
+
+		return
+	}
+
+	s.commitUpload(w, r, u, f, name)
+}
+
+// chunkUpload retrieves the upload the chunk belongs to, starting a new one with the first chunk.
+// Returns nil if there is no upload in progress to continue.
+// s.mu is only held to look the upload up, not while the chunk is received.
+func (s *Storage) chunkUpload(name string, cr contentRange) (*upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireUploads(time.Now())
+
+	if cr.first == 0 {
+		return s.startUpload(name, cr.total)
+	}
+
+	return s.uploads[name], nil
+}
+
+// commitUpload replaces the state with a completely received upload,
+// once it passes the same checks as a state written at once.
+// The caller must hold u.mu.
+func (s *Storage) commitUpload(w http.ResponseWriter, r *http.Request, u *upload, f *os.File, name string) {
+	u.discarded = true
+
+	s.mu.Lock()
+	delete(s.uploads, name)
+	s.mu.Unlock()
+
+	data, err := os.ReadFile(f.Name())
+
+	exists := false
+	if err == nil {
+		exists, err = s.exists(name)
+	}
+
+	if err != nil || !s.checkState(w, r, name, data) {
+		f.Close()
+		os.Remove(f.Name())
+
+		if err != nil {
+			writeError(w, "failed to check resumable upload", name, err)
+		}
+
+		return
+	}
+
+	if err := s.commitFile(f, filepath.Join(s.path, name+stateFileExt)); err != nil {
+		writeError(w, "failed to commit resumable upload", name, err)
+
+		return
+	}
+
+	s.metrics.stateWrites.inc(s.redactor.redact(name))
+	log.Debug("resumable upload committed", "name", name)
+
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func postChunk(t *testing.T, storage *Storage, contentRange string, chunk []byte) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(chunk))
+	req.Header.Set("Content-Range", contentRange)
+
+	w := httptest.NewRecorder()
+
+	storage.handlePost(w, req, name)
+
+	return w.Result()
+}
+
+func TestStorageHandlePostResumable(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.resumable = true
+
+	res := postChunk(t, storage, "bytes 0-4/11", []byte("hello"))
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status code for first chunk: got %d, want %d", res.StatusCode, http.StatusAccepted)
+	}
+
+	if got := res.Header.Get("Range"); got != "bytes=0-4" {
+		t.Fatalf("unexpected range: got %q, want %q", got, "bytes=0-4")
+	}
+
+	if exists, _ := storage.exists(name); exists {
+		t.Fatal("state committed before upload is complete")
+	}
+
+	res = postChunk(t, storage, "bytes 3-7/11", []byte("lo wo"))
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("unexpected status code for overlapping chunk: got %d, want %d",
+			res.StatusCode, http.StatusRequestedRangeNotSatisfiable)
+	}
+
+	res = postChunk(t, storage, "bytes 5-10/11", []byte(" world"))
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code for last chunk: got %d, want %d", res.StatusCode, http.StatusCreated)
+	}
+
+	data, err := os.ReadFile(filepath.Join(storage.path, name+stateFileExt))
+	if err != nil {
+		t.Fatalf("failed to read state file: %v", err)
+	}
+
+	if string(data) != "hello world" {
+		t.Fatalf("unexpected state content: got %q, want %q", data, "hello world")
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(storage.path, "*"+partFileExt)); len(matches) != 0 {
+		t.Fatalf("partial files left behind: %v", matches)
+	}
+}
+
+func TestStorageHandlePostResumableRejected(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		current string
+		chunk   string
+		status  int
+	}{
+		{"blank", "", "     ", http.StatusBadRequest},
+		{"shrink", "hello world", "hello", http.StatusConflict},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			storage := setupTestStorage(t)
+			storage.resumable = true
+			storage.shrinkThreshold = 50
+
+			filePath := filepath.Join(storage.path, name+stateFileExt)
+			if tc.current != "" {
+				writeTestFile(t, filePath, tc.current)
+			}
+
+			res := postChunk(t, storage, "bytes 0-4/5", []byte(tc.chunk))
+			defer res.Body.Close()
+
+			if res.StatusCode != tc.status {
+				t.Fatalf("unexpected status code: got %d, want %d", res.StatusCode, tc.status)
+			}
+
+			if data, _ := os.ReadFile(filePath); string(data) != tc.current {
+				t.Fatalf("unexpected state content: got %q, want %q", data, tc.current)
+			}
+
+			if matches, _ := filepath.Glob(filepath.Join(storage.path, "*"+partFileExt)); len(matches) != 0 {
+				t.Fatalf("partial files left behind: %v", matches)
+			}
+		})
+	}
+}
+
+func TestStorageHandlePostResumableStalledChunk(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.resumable = true
+
+	body, stall := io.Pipe()
+	stalled := make(chan struct{})
+
+	defer func() {
+		stall.Close()
+		<-stalled
+	}()
+
+	req := httptest.NewRequest(http.MethodPost, "/"+name, body)
+	req.Header.Set("Content-Range", "bytes 0-4/5")
+	req.ContentLength = 5
+
+	go func() {
+		storage.handlePost(httptest.NewRecorder(), req, name)
+		close(stalled)
+	}()
+
+	done := make(chan int)
+
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/other", bytes.NewReader([]byte("hello")))
+		req.Header.Set("Content-Range", "bytes 0-4/5")
+
+		w := httptest.NewRecorder()
+		storage.handlePost(w, req, "other")
+		done <- w.Code
+	}()
+
+	select {
+	case code := <-done:
+		if code != http.StatusCreated {
+			t.Fatalf("unexpected status code: got %d, want %d", code, http.StatusCreated)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("upload blocked by a stalled chunk of another state")
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{"", "bytes 0-4", "bytes 5-4/10", "bytes 0-10/10", "items 0-4/10", "bytes -1-4/10"} {
+		if _, err := parseContentRange(value); err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+
+	got, err := parseContentRange("bytes 5-9/10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got != (contentRange{first: 5, last: 9, total: 10}) {
+		t.Fatalf("unexpected range: got %+v", got)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build 386 || amd64 || arm
+
+package main
+
+// soReusePort is SO_REUSEPORT, which the syscall package lacks on these architectures.
+const soReusePort = 0xf
@@ -0,0 +1,14 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// reusePort is not supported on this platform, listening with it fails.
+func reusePort(_, _ string, _ syscall.RawConn) error {
+	return fmt.Errorf("failed to set SO_REUSEPORT: %w", errors.ErrUnsupported)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestReusePort(t *testing.T) {
+	t.Parallel()
+
+	lc := net.ListenConfig{Control: reusePort}
+
+	first, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("SO_REUSEPORT not supported")
+	}
+
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	defer first.Close()
+
+	second, err := lc.Listen(context.Background(), "tcp", first.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to listen on the same address: %v", err)
+	}
+
+	second.Close()
+}
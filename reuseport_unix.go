@@ -0,0 +1,27 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// reusePort sets SO_REUSEPORT on the listening socket, so another process can bind the same address.
+// It is meant to be used as net.ListenConfig.Control.
+func reusePort(_, _ string, c syscall.RawConn) error {
+	var sockErr error
+
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to access socket: %w", err)
+	}
+
+	if sockErr != nil {
+		return fmt.Errorf("failed to set SO_REUSEPORT: %w", sockErr)
+	}
+
+	return nil
+}
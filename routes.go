@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// handlerMux is the part of http.ServeMux handlers are registered with.
+type handlerMux interface {
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
+// route represents a registered pattern and the name of the handler serving it.
+type route struct {
+	pattern string
+	handler string
+}
+
+// routeMux is a ServeMux recording the routes registered on it, as ServeMux doesn't list them.
+type routeMux struct {
+	*http.ServeMux
+
+	routes []route
+}
+
+// closureSuffix matches the suffixes the runtime adds to names of method values and closures.
+var closureSuffix = regexp.MustCompile(`(-fm|\.func\d+)+$`)
+
+// funcName returns the name of the function without the package and closure suffixes, e.g. (*Storage).handleGet.
+func funcName(f any) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+
+	// Names are qualified by the package path, e.g. example.com/pkg.(*T).Method.
+	name := fn.Name()[strings.LastIndex(fn.Name(), "/")+1:]
+	_, name, _ = strings.Cut(name, ".")
+
+	return closureSuffix.ReplaceAllString(name, "")
+}
+
+func (m *routeMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.routes = append(m.routes, route{pattern: pattern, handler: funcName(handler)})
+	m.ServeMux.HandleFunc(pattern, handler)
+}
+
+// handleAdmin registers the handler behind the admin token check, recorded under the name of the handler.
+func (m *routeMux) handleAdmin(pattern, token string, handler http.HandlerFunc) {
+	m.routes = append(m.routes, route{pattern: pattern, handler: funcName(handler) + " (admin)"})
+	m.ServeMux.HandleFunc(pattern, requireAdmin(token, handler))
+}
+
+// effectiveRoutes returns the registered routes with the state and action dispatchers expanded
+// into a route per method and action they serve.
+func (m *routeMux) effectiveRoutes(s *Storage) []route {
+	dispatched := func(prefix string, handlers map[string]stateHandler, path func(key string) string) []route {
+		routes := make([]route, 0, len(handlers))
+
+		for _, key := range slices.Sorted(maps.Keys(handlers)) {
+			routes = append(routes, route{pattern: path(key), handler: funcName(handlers[key])})
+		}
+
+		return append([]route{{pattern: prefix, handler: "dispatch"}}, routes...)
+	}
+
+	var routes []route
+
+	for _, r := range m.routes {
+		switch r.handler {
+		case funcName(s.handleState):
+			routes = append(routes, dispatched(r.pattern, s.stateHandlers(), func(method string) string {
+				return method + " " + r.pattern
+			})...)
+		case funcName(s.handleAction):
+			routes = append(routes, dispatched(r.pattern, s.actionHandlers(), func(key string) string {
+				method, action, _ := strings.Cut(key, " ")
+
+				return method + " " + strings.Replace(r.pattern, "{action}", action, 1)
+			})...)
+		default:
+			routes = append(routes, r)
+		}
+	}
+
+	return routes
+}
+
+// printRoutes writes the routes as a table to out.
+func printRoutes(out io.Writer, routes []route) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0) //nolint:mnd // padding between columns
+
+	fmt.Fprintln(tw, "PATTERN\tHANDLER")
+
+	for _, r := range routes {
+		fmt.Fprintf(tw, "%s\t%s\n", r.pattern, r.handler)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("failed to print routes: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteMuxEffectiveRoutes(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	mux := &routeMux{ServeMux: http.NewServeMux()}
+
+	storage.registerRoutes(mux)
+	mux.handleAdmin("POST /admin/locks/purge", "token", storage.handlePurgeLocks)
+
+	var out bytes.Buffer
+
+	if err := printRoutes(&out, mux.effectiveRoutes(storage)); err != nil {
+		t.Fatalf("failed to print routes: %v", err)
+	}
+
+	lines := map[string]bool{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		lines[strings.Join(strings.Fields(line), " ")] = true
+	}
+
+	want := []string{
+		"GET /metrics (*Metrics).handleMetrics",
+		"/{name} dispatch",
+		"GET /{name} (*Storage).handleGet",
+		"LOCK /{name} (*Storage).handleLock",
+		"POST /{name}/touch (*Storage).handleTouch",
+		"PUT /{name}/tags (*Storage).handlePutTags",
+		"POST /admin/locks/purge (*Storage).handlePurgeLocks (admin)",
+	}
+
+	for _, w := range want {
+		if !lines[w] {
+			t.Errorf("route %q not printed:\n%s", w, out.String())
+		}
+	}
+}
+
+func TestFuncName(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	tests := []struct {
+		f    any
+		want string
+	}{
+		{storage.allStates, "(*Storage).allStates"},
+		{handleRuntime(time.Now()), "handleRuntime"},
+		{funcName, "funcName"},
+	}
+
+	for _, tt := range tests {
+		if got := funcName(tt.f); got != tt.want {
+			t.Errorf("unexpected name: got %q, want %q", got, tt.want)
+		}
+	}
+}
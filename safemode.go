@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	log "log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const safeModeHeader = "X-Safe-Mode-Token" // Header carrying the admin token of writes allowed in safe mode.
+
+// safeMode freezes the states while enabled: write requests are rejected,
+// except those carrying the admin token in safeModeHeader.
+type safeMode struct {
+	enabled atomic.Bool
+	token   string // Admin token allowing writes in safe mode, none are allowed if empty.
+}
+
+// newSafeMode retrieves a safe mode, enabled or not, whose writes are allowed with the token.
+func newSafeMode(enabled bool, token string) *safeMode {
+	m := &safeMode{token: token}
+	m.enabled.Store(enabled)
+
+	return m
+}
+
+// isWrite reports whether requests with the method may change the storage.
+func isWrite(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// guard wraps the handler to reject write requests with 503 while safe mode is enabled.
+// The admin endpoints are left to their own token check, so safe mode can be disabled through them.
+// Writes allowed with the admin token are audit-logged.
+func (m *safeMode) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.enabled.Load() || !isWrite(r.Method) || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		token := r.Header.Get(safeModeHeader)
+		if m.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(m.token)) != 1 {
+			log.Warn("write rejected in safe mode", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+			http.Error(w, "Service Unavailable: safe mode, writes are frozen", http.StatusServiceUnavailable)
+
+			return
+		}
+
+		setAccessUser(r, "admin")
+		audit(r, "write in safe mode")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleSafeMode is HTTP handler for POST /admin/safe-mode.
+// It enables or disables safe mode according to the enabled query parameter.
+func (m *safeMode) handleSafeMode(w http.ResponseWriter, r *http.Request) {
+	enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+	if err != nil {
+		http.Error(w, "Bad Request: invalid enabled", http.StatusBadRequest)
+
+		return
+	}
+
+	m.enabled.Store(enabled)
+	audit(r, "safe mode toggled", "enabled", enabled)
+
+	type Result struct {
+		Status   string `json:"status"`
+		SafeMode bool   `json:"safeMode"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(Result{Status: "ok", SafeMode: enabled}); err != nil {
+		log.Error("failed to encode JSON:", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSafeModeGuard(t *testing.T) {
+	t.Parallel()
+
+	safe := newSafeMode(true, "secret")
+	handler := safe.guard(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	testCases := []struct {
+		method string
+		target string
+		token  string
+		want   int
+	}{
+		{http.MethodGet, "/test", "", http.StatusNoContent},
+		{http.MethodHead, "/test", "", http.StatusNoContent},
+		{http.MethodPost, "/test", "", http.StatusServiceUnavailable},
+		{"LOCK", "/test", "", http.StatusServiceUnavailable},
+		{http.MethodDelete, "/test", "wrong", http.StatusServiceUnavailable},
+		{http.MethodPost, "/test", "secret", http.StatusNoContent},
+		{http.MethodPost, "/admin/safe-mode?enabled=false", "", http.StatusNoContent},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.token != "" {
+			req.Header.Set(safeModeHeader, tc.token)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tc.want {
+			t.Fatalf("unexpected status code for %s %s: got %d, want %d", tc.method, tc.target, w.Code, tc.want)
+		}
+	}
+
+	safe.enabled.Store(false)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/test", nil))
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status code with safe mode disabled: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestSafeModeWithoutToken(t *testing.T) {
+	t.Parallel()
+
+	handler := newSafeMode(true, "").guard(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/test", nil)
+	req.Header.Set(safeModeHeader, "")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestSafeModeHandler(t *testing.T) {
+	t.Parallel()
+
+	safe := newSafeMode(false, "secret")
+
+	for _, tc := range []struct {
+		target string
+		want   int
+		safe   bool
+	}{
+		{"/admin/safe-mode?enabled=true", http.StatusOK, true},
+		{"/admin/safe-mode?enabled=maybe", http.StatusBadRequest, true},
+		{"/admin/safe-mode?enabled=false", http.StatusOK, false},
+	} {
+		w := httptest.NewRecorder()
+		safe.handleSafeMode(w, httptest.NewRequest(http.MethodPost, tc.target, nil))
+
+		if w.Code != tc.want {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", tc.target, w.Code, tc.want)
+		}
+
+		if safe.enabled.Load() != tc.safe {
+			t.Fatalf("unexpected safe mode after %s: got %v, want %v", tc.target, safe.enabled.Load(), tc.safe)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const maxShrinkThreshold = 100 // Shrink thresholds are percentages.
+
+var ErrShrink = errors.New("state shrinks")
+
+// resourceCount returns the number of resource instances of a Terraform state.
+// Returns false if data isn't a state with a resources list.
+func resourceCount(data []byte) (int, bool) {
+	var state struct {
+		Resources *[]struct {
+			Instances []json.RawMessage `json:"instances"`
+		} `json:"resources"`
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil || state.Resources == nil {
+		return 0, false
+	}
+
+	count := 0
+	for _, r := range *state.Resources {
+		count += len(r.Instances)
+	}
+
+	return count, true
+}
+
+// stateSize returns the size of both states compared by the shrink guard and what they are measured in.
+// States are compared by resource instances if both can be parsed, by bytes otherwise.
+func stateSize(current, data []byte) (int, int, string) {
+	before, ok := resourceCount(current)
+	after, ok2 := resourceCount(data)
+
+	if !ok || !ok2 {
+		return len(current), len(data), "bytes"
+	}
+
+	return before, after, "resources"
+}
+
+// checkShrink returns ErrShrink if data is smaller than the current state by more than shrinkThreshold percent.
+// The check is skipped if the request has allow-shrink=true or the state doesn't exist.
+func (s *Storage) checkShrink(r *http.Request, name string, data []byte) error {
+	if s.shrinkThreshold <= 0 {
+		return nil
+	}
+
+	if allow, _ := strconv.ParseBool(r.URL.Query().Get("allow-shrink")); allow {
+		return nil
+	}
+
+	current, err := os.ReadFile(filepath.Join(s.path, name+stateFileExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+
+	before, after, unit := stateSize(current, data)
+
+	if int64(after)*maxShrinkThreshold < int64(before)*(maxShrinkThreshold-s.shrinkThreshold) {
+		return fmt.Errorf("%w from %d to %d %s", ErrShrink, before, after, unit)
+	}
+
+	return nil
+}
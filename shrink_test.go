@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testState returns a Terraform state with the number of resource instances.
+func testState(instances int) string {
+	return `{"version":4,"resources":[{"type":"null_resource","instances":[` +
+		strings.TrimSuffix(strings.Repeat(`{"attributes":{}},`, instances), ",") + `]}]}`
+}
+
+func TestResourceCount(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		data  string
+		count int
+		ok    bool
+	}{
+		{testState(3), 3, true},
+		{`{"version":4,"resources":[]}`, 0, true},
+		{`{"version":4}`, 0, false},
+		{`{"resources":"none"}`, 0, false},
+		{"not json", 0, false},
+	}
+
+	for _, tt := range tests {
+		count, ok := resourceCount([]byte(tt.data))
+		if count != tt.count || ok != tt.ok {
+			t.Errorf("resourceCount(%q) = %d, %v, want %d, %v", tt.data, count, ok, tt.count, tt.ok)
+		}
+	}
+}
+
+func TestStorageShrinkThreshold(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		current string
+		data    string
+		target  string
+		want    int
+	}{
+		{"no state", "", testState(0), "/test", http.StatusCreated},
+		{"within threshold", testState(10), testState(5), "/test", http.StatusOK},
+		{"truncated", testState(10), testState(4), "/test", http.StatusConflict},
+		{"emptied", testState(10), `{"version":4,"resources":[]}`, "/test", http.StatusConflict},
+		{"allowed", testState(10), testState(0), "/test?allow-shrink=true", http.StatusOK},
+		{"growing", testState(1), testState(10), "/test", http.StatusOK},
+		{"bytes", strings.Repeat("x", 100), "x", "/test", http.StatusConflict},
+		{"not a state", testState(10), "x", "/test", http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			storage := setupTestStorage(t)
+			storage.shrinkThreshold = 50
+
+			filePath := filepath.Join(storage.path, name+stateFileExt)
+
+			if tt.current != "" {
+				if err := os.WriteFile(filePath, []byte(tt.current), defaultFileMode); err != nil {
+					t.Fatalf("failed to write state: %v", err)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			storage.handlePost(w, httptest.NewRequest(http.MethodPost, tt.target, bytes.NewBufferString(tt.data)), name)
+
+			if w.Code != tt.want {
+				t.Fatalf("unexpected status code: got %d, want %d", w.Code, tt.want)
+			}
+
+			if tt.want != http.StatusConflict {
+				return
+			}
+
+			data, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatalf("failed to read state: %v", err)
+			}
+
+			if string(data) != tt.current {
+				t.Fatalf("state overwritten: got %q, want %q", data, tt.current)
+			}
+		})
+	}
+}
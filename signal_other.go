@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import log "log/slog"
+
+// notifyToggleDebug is a no-op on platforms without SIGUSR2.
+func notifyToggleDebug(_ *log.LevelVar, _ log.Level) {}
+
+// notifyToggleSafeMode is a no-op on platforms without SIGUSR1.
+func notifyToggleSafeMode(_ *safeMode) {}
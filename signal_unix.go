@@ -0,0 +1,37 @@
+//go:build unix
+
+package main
+
+import (
+	log "log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyToggleDebug toggles debug logging each time the process receives SIGUSR2.
+func notifyToggleDebug(lv *log.LevelVar, configured log.Level) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR2)
+
+	go func() {
+		for range ch {
+			level := toggleDebug(lv, configured)
+			log.Info("log level toggled", "signal", "SIGUSR2", "level", level)
+		}
+	}()
+}
+
+// notifyToggleSafeMode toggles safe mode each time the process receives SIGUSR1.
+func notifyToggleSafeMode(m *safeMode) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+
+	go func() {
+		for range ch {
+			enabled := !m.enabled.Load()
+			m.enabled.Store(enabled)
+			log.Warn("safe mode toggled", "signal", "SIGUSR1", "enabled", enabled)
+		}
+	}()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	log "log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers of signed requests.
+const (
+	signatureHeader          = "X-Signature"           // Hex encoded HMAC-SHA256 of the request.
+	signatureTimestampHeader = "X-Signature-Timestamp" // Unix time the request was signed at.
+	signatureNonceHeader     = "X-Signature-Nonce"     // Value unique to the request.
+
+	signatureWindow = 5 * time.Minute // Maximum difference between the signing time and the server clock.
+
+	defaultMaxSignedBody = 64 << 20 // Limit for signed request bodies in bytes without a maximum state size.
+	signedBodyOverhead   = 64 << 10 // Room above the maximum state size for the multipart framing and tags of imports.
+)
+
+var (
+	ErrSignatureMissing = errors.New("missing signature")
+	ErrSignatureStale   = errors.New("signature outside time window")
+	ErrSignatureInvalid = errors.New("invalid signature")
+	ErrSignatureReplay  = errors.New("replayed nonce")
+)
+
+// signer verifies request signatures and remembers the nonces seen within the time window.
+type signer struct {
+	secret []byte // Shared secret of the HMAC.
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time // Time nonces were seen at.
+}
+
+// newSigner retrieves a signer verifying signatures made with the shared secret.
+func newSigner(secret string) *signer {
+	return &signer{secret: []byte(secret), window: signatureWindow, seen: make(map[string]time.Time)}
+}
+
+// signature returns the hex encoded HMAC-SHA256 of the request fields, one per line followed by the body.
+// The target is the request URI, path and query, so a signature is only valid for the action it was made for.
+func (g *signer) signature(method, target, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, g.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n", method, target, timestamp, nonce)
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkHeaders returns an error unless the signature headers are present and signed within the time window.
+// It doesn't need the body, so requests failing it are rejected before their body is read.
+func (g *signer) checkHeaders(r *http.Request, now time.Time) error {
+	timestamp := r.Header.Get(signatureTimestampHeader)
+
+	if r.Header.Get(signatureHeader) == "" || timestamp == "" || r.Header.Get(signatureNonceHeader) == "" {
+		return ErrSignatureMissing
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: timestamp %q", ErrSignatureInvalid, timestamp)
+	}
+
+	if signed := time.Unix(unix, 0); signed.Before(now.Add(-g.window)) || signed.After(now.Add(g.window)) {
+		return fmt.Errorf("%w: signed at %s", ErrSignatureStale, signed.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// verify returns an error unless the request is signed, within the time window and not replayed.
+func (g *signer) verify(r *http.Request, body []byte, now time.Time) error {
+	if err := g.checkHeaders(r, now); err != nil {
+		return err
+	}
+
+	sig := r.Header.Get(signatureHeader)
+	timestamp := r.Header.Get(signatureTimestampHeader)
+	nonce := r.Header.Get(signatureNonceHeader)
+
+	if !hmac.Equal([]byte(sig), []byte(g.signature(r.Method, r.URL.RequestURI(), timestamp, nonce, body))) {
+		return ErrSignatureInvalid
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Nonces older than both windows can't pass the timestamp check anymore.
+	for n, seen := range g.seen {
+		if now.Sub(seen) > 2*g.window {
+			delete(g.seen, n)
+		}
+	}
+
+	if _, ok := g.seen[nonce]; ok {
+		return ErrSignatureReplay
+	}
+
+	g.seen[nonce] = now
+
+	return nil
+}
+
+// signedBodyLimit returns the maximum body size of a signed request, which is read whole before it is verified.
+func (s *Storage) signedBodyLimit(r *http.Request) int64 {
+	switch {
+	case r.Method == "LOCK" || r.Method == "UNLOCK":
+		return s.maxLockBody
+	case s.maxStateSize > 0:
+		return s.maxStateSize + signedBodyOverhead
+	default:
+		return defaultMaxSignedBody
+	}
+}
+
+// checkSignature responds with 401 unless a mutating request is validly signed, if signing is enabled.
+// The body is read for the signature and put back for the handler, once the headers passed.
+// Bodies over signedBodyLimit are rejected with 413 before the signature is checked.
+// Returns true if the request may proceed.
+func (s *Storage) checkSignature(w http.ResponseWriter, r *http.Request, name string) bool {
+	if s.signer == nil || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+
+	if err := s.signer.checkHeaders(r, time.Now()); err != nil {
+		log.Warn("request signature rejected", "method", r.Method, "name", name, "remote", r.RemoteAddr, "error", err)
+		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+
+		return false
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.signedBodyLimit(r)))
+	if maxErr := new(http.MaxBytesError); errors.As(err, &maxErr) {
+		log.Warn("signed request too large", "name", name, "limit", maxErr.Limit)
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+
+		return false
+	}
+
+	if err != nil {
+		log.Warn("failed to read signed request body", "name", name, "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+
+		return false
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(data))
+
+	if err := s.signer.verify(r, data, time.Now()); err != nil {
+		log.Warn("request signature rejected", "method", r.Method, "name", name, "remote", r.RemoteAddr, "error", err)
+		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStorageCheckSignature(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.signer = newSigner("secret")
+
+	mux := http.NewServeMux()
+	storage.registerRoutes(mux)
+
+	type signed struct {
+		method    string
+		body      string
+		signedAt  time.Time
+		nonce     string
+		secret    string
+		unsigned  bool
+		tamper    bool
+		want      int
+		wantState string
+	}
+
+	now := time.Now()
+
+	testCases := []signed{
+		{method: http.MethodPost, body: "v1", signedAt: now, nonce: "1", secret: "secret", want: http.StatusCreated},
+		{method: http.MethodPost, body: "v1", signedAt: now, nonce: "1", secret: "secret", want: http.StatusUnauthorized},
+		{method: http.MethodPost, body: "v2", unsigned: true, want: http.StatusUnauthorized},
+		{method: http.MethodPost, body: "v2", signedAt: now, nonce: "2", secret: "other", want: http.StatusUnauthorized},
+		{method: http.MethodPost, body: "v2", signedAt: now, nonce: "3", secret: "secret", tamper: true,
+			want: http.StatusUnauthorized},
+		{method: http.MethodPost, body: "v2", signedAt: now.Add(-10 * time.Minute), nonce: "4", secret: "secret",
+			want: http.StatusUnauthorized},
+		{method: http.MethodGet, unsigned: true, want: http.StatusOK, wantState: "v1"},
+		{method: "LOCK", signedAt: now, nonce: "5", secret: "secret", want: http.StatusOK},
+	}
+
+	for i, tc := range testCases {
+		req := httptest.NewRequest(tc.method, "/"+name, bytes.NewBufferString(tc.body))
+
+		if !tc.unsigned {
+			timestamp := strconv.FormatInt(tc.signedAt.Unix(), 10)
+			sig := newSigner(tc.secret).signature(tc.method, "/"+name, timestamp, tc.nonce, []byte(tc.body))
+
+			if tc.tamper {
+				req = httptest.NewRequest(tc.method, "/"+name, bytes.NewBufferString(tc.body+"!"))
+			}
+
+			req.Header.Set(signatureHeader, sig)
+			req.Header.Set(signatureTimestampHeader, timestamp)
+			req.Header.Set(signatureNonceHeader, tc.nonce)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != tc.want {
+			t.Fatalf("unexpected status code for case %d: got %d, want %d", i, w.Code, tc.want)
+		}
+
+		if tc.wantState != "" && w.Body.String() != tc.wantState {
+			t.Fatalf("unexpected state for case %d: got %q, want %q", i, w.Body.String(), tc.wantState)
+		}
+	}
+}
+
+func TestSignerForgetsOldNonces(t *testing.T) {
+	t.Parallel()
+
+	g := newSigner("secret")
+	g.seen["old"] = time.Now().Add(-time.Hour)
+	g.seen["young"] = time.Now()
+
+	req := httptest.NewRequest(http.MethodPost, "/"+name, nil)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(signatureHeader, g.signature(http.MethodPost, "/"+name, timestamp, "new", nil))
+	req.Header.Set(signatureTimestampHeader, timestamp)
+	req.Header.Set(signatureNonceHeader, "new")
+
+	if err := g.verify(req, nil, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := g.seen["old"]; ok || len(g.seen) != 2 {
+		t.Fatalf("unexpected nonces: %v", g.seen)
+	}
+}
+
+func signedRequest(t *testing.T, method, target, signedTarget, nonce string, body []byte) *http.Request {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(signatureHeader, newSigner("secret").signature(method, signedTarget, timestamp, nonce, body))
+	req.Header.Set(signatureTimestampHeader, timestamp)
+	req.Header.Set(signatureNonceHeader, nonce)
+
+	return req
+}
+
+func TestStorageCheckSignatureTarget(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.signer = newSigner("secret")
+
+	mux := http.NewServeMux()
+	storage.registerRoutes(mux)
+
+	writeTestFile(t, filepath.Join(storage.path, name+stateFileExt), "content")
+
+	testCases := []struct {
+		target       string
+		signedTarget string
+		want         int
+	}{
+		{"/" + name + "/move?to=evil", "/" + name + "/move?to=good", http.StatusUnauthorized},
+		{"/" + name + "/touch", "/" + name, http.StatusUnauthorized},
+		{"/" + name + "/move?to=good", "/" + name + "/move?to=good", http.StatusCreated},
+	}
+
+	for i, tc := range testCases {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, signedRequest(t, http.MethodPost, tc.target, tc.signedTarget, strconv.Itoa(i), nil))
+
+		if w.Code != tc.want {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", tc.target, w.Code, tc.want)
+		}
+	}
+
+	if exists, _ := storage.exists("evil"); exists {
+		t.Fatal("state moved with a changed query")
+	}
+}
+
+func TestStorageCheckSignatureBodyLimit(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.signer = newSigner("secret")
+	storage.maxLockBody = 16
+
+	mux := http.NewServeMux()
+	storage.registerRoutes(mux)
+
+	body := bytes.Repeat([]byte("x"), 1024)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, signedRequest(t, "LOCK", "/"+name, "/"+name, "1", body))
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	log "log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// staleWindow retrieves the age after which states are stale, the stale-after query parameter
+// or the configured one. It responds with 400 and returns false if the parameter is invalid.
+func (s *Storage) staleWindow(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	v := r.URL.Query().Get("stale-after")
+	if v == "" {
+		return s.staleAfter, true
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		http.Error(w, "Bad Request: invalid stale-after", http.StatusBadRequest)
+
+		return 0, false
+	}
+
+	return d, true
+}
+
+// oldestStateAge retrieves the time since the least recently modified state was written, in seconds.
+// Returns ErrNotExists if there are no states.
+func (s *Storage) oldestStateAge() (float64, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read directory %s: %w", s.path, err)
+	}
+
+	var oldest time.Time
+
+	err = processEntries(s.path, entries, stateFileExt, func(_ string, info fs.FileInfo) error {
+		if oldest.IsZero() || info.ModTime().Before(oldest) {
+			oldest = info.ModTime()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if oldest.IsZero() {
+		return 0, ErrNotExists
+	}
+
+	return time.Since(oldest).Seconds(), nil
+}
+
+// StaleStates represents the states not modified within the stale window.
+type StaleStates struct {
+	Status     string  `json:"status"`
+	StaleAfter string  `json:"staleAfter"`
+	States     *States `json:"states"`
+}
+
+// handleStaleStats is HTTP handler for GET /stats/stale.
+// It lists the states not modified within the stale window, the same the listing marks as stale,
+// least recently modified first.
+func (s *Storage) handleStaleStats(w http.ResponseWriter, r *http.Request) {
+	staleAfter, ok := s.staleWindow(w, r)
+	if !ok {
+		return
+	}
+
+	if staleAfter <= 0 {
+		http.Error(w, "Bad Request: missing stale-after", http.StatusBadRequest)
+
+		return
+	}
+
+	states, err := s.listStates()
+	if err != nil {
+		log.Error("failed to list states:", "path", s.path, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+
+		return
+	}
+
+	states.MarkStale(time.Now().Add(-staleAfter))
+
+	stale := States{}
+
+	for _, state := range states {
+		if state.Stale {
+			stale = append(stale, state)
+		}
+	}
+
+	if err := stale.Sort(sortUpdated); err != nil {
+		log.Error("failed to sort states:", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err = json.NewEncoder(w).Encode(StaleStates{Status: "ok", StaleAfter: staleAfter.String(), States: &stale})
+	if err != nil {
+		log.Error("failed to encode JSON:", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStorageHandleStaleStats(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	if _, err := storage.oldestStateAge(); !errors.Is(err, ErrNotExists) {
+		t.Fatalf("unexpected error without states: %v", err)
+	}
+
+	now := time.Now()
+
+	for state, age := range map[string]time.Duration{"fresh": 0, "old": 48 * time.Hour, "older": 96 * time.Hour} {
+		path := filepath.Join(storage.path, state+stateFileExt)
+		writeTestFile(t, path, "content")
+
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatalf("failed to age state: %v", err)
+		}
+	}
+
+	age, err := storage.oldestStateAge()
+	if err != nil || age < (96*time.Hour).Seconds() || age > (97*time.Hour).Seconds() {
+		t.Fatalf("unexpected oldest state age: %v, error %v", age, err)
+	}
+
+	stale := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		storage.handleStaleStats(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+		return w
+	}
+
+	for _, target := range []string{"/stats/stale", "/stats/stale?stale-after=soon"} {
+		if w := stale(target); w.Code != http.StatusBadRequest {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	storage.staleAfter = 24 * time.Hour
+
+	for target, want := range map[string][]string{
+		"/stats/stale":                  {"older", "old"},
+		"/stats/stale?stale-after=72h":  {"older"},
+		"/stats/stale?stale-after=240h": {},
+	} {
+		w := stale(target)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", target, w.Code, http.StatusOK)
+		}
+
+		var result StaleStates
+
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+
+		if len(*result.States) != len(want) {
+			t.Fatalf("unexpected stale states for %s: got %d, want %v", target, len(*result.States), want)
+		}
+
+		for i, state := range *result.States {
+			if state.Name != want[i] || !state.Stale {
+				t.Fatalf("unexpected stale state %d for %s: got %+v, want %s", i, target, state, want[i])
+			}
+		}
+	}
+}
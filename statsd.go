@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	log "log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	statsdFlushInterval = 10 * time.Second // Interval metrics are pushed to StatsD at.
+	statsdMaxPacket     = 1432             // Maximum datagram size fitting an Ethernet frame.
+)
+
+// statsdExporter pushes the metrics to a StatsD server in the DogStatsD format.
+// Labels are sent as tags, counters as the increase since the previous flush.
+type statsdExporter struct {
+	conn    net.Conn
+	metrics *Metrics
+	sent    map[string]float64 // Counter values sent so far by metric name and tag.
+}
+
+// newStatsdExporter retrieves an exporter pushing the metrics to the StatsD server at addr over UDP.
+func newStatsdExporter(addr string, metrics *Metrics) (*statsdExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to StatsD at %s: %w", addr, err)
+	}
+
+	return &statsdExporter{conn: conn, metrics: metrics, sent: make(map[string]float64)}, nil
+}
+
+// line formats the sample in the DogStatsD format.
+// Returns an empty line if a counter didn't change since the previous flush.
+func (e *statsdExporter) line(s sample) string {
+	tags := ""
+	if s.label != "" {
+		tags = "|#" + s.label + ":" + strings.NewReplacer(",", "_", "|", "_").Replace(s.labelValue)
+	}
+
+	value, kind := s.value, "g"
+
+	if s.kind == metricCounter {
+		key := s.name + tags
+		value, kind = s.value-e.sent[key], "c"
+
+		// A counter lower than the value sent is one that was reset.
+		if value < 0 {
+			value = s.value
+		}
+
+		e.sent[key] = s.value
+
+		if value == 0 {
+			return ""
+		}
+	}
+
+	return s.name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + kind + tags
+}
+
+// flush sends the current metrics, batching lines into datagrams of up to statsdMaxPacket bytes.
+func (e *statsdExporter) flush() error {
+	var packet strings.Builder
+
+	send := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+
+		_, err := e.conn.Write([]byte(packet.String()))
+		packet.Reset()
+
+		if err != nil {
+			return fmt.Errorf("failed to send metrics to StatsD: %w", err)
+		}
+
+		return nil
+	}
+
+	for _, m := range e.metrics.all() {
+		for _, s := range m.samples() {
+			line := e.line(s)
+			if line == "" {
+				continue
+			}
+
+			if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+				if err := send(); err != nil {
+					return err
+				}
+			}
+
+			if packet.Len() > 0 {
+				packet.WriteByte('\n')
+			}
+
+			packet.WriteString(line)
+		}
+	}
+
+	return send()
+}
+
+// run flushes the metrics every interval, it never returns.
+// Pushing happens apart from request handling, so a slow or missing StatsD server never delays requests.
+func (e *statsdExporter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := e.flush(); err != nil {
+			log.Debug("failed to push metrics", "error", err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsdExporter(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	metrics := NewMetrics()
+	metrics.freeSpace = &gaugeFunc{
+		name:  "terraform_backend_free_space_bytes",
+		value: func() (float64, error) { return 4096, nil },
+	}
+
+	exporter, err := newStatsdExporter(listener.LocalAddr().String(), metrics)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	receive := func() []string {
+		t.Helper()
+
+		if err := exporter.flush(); err != nil {
+			t.Fatalf("failed to flush: %v", err)
+		}
+
+		buf := make([]byte, statsdMaxPacket)
+
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("failed to receive metrics: %v", err)
+		}
+
+		lines := strings.Split(string(buf[:n]), "\n")
+		slices.Sort(lines)
+
+		return lines
+	}
+
+	metrics.rejectedMethods.inc("LOCK")
+	metrics.rejectedMethods.inc("LOCK")
+	metrics.rejectedMethods.inc("other")
+
+	want := []string{
+		"terraform_backend_free_space_bytes:4096|g",
+		"terraform_backend_rejected_method_total:1|c|#method:other",
+		"terraform_backend_rejected_method_total:2|c|#method:LOCK",
+	}
+
+	if got := receive(); !slices.Equal(got, want) {
+		t.Fatalf("unexpected metrics: got %q, want %q", got, want)
+	}
+
+	metrics.rejectedMethods.inc("LOCK")
+
+	want = []string{
+		"terraform_backend_free_space_bytes:4096|g",
+		"terraform_backend_rejected_method_total:1|c|#method:LOCK",
+	}
+
+	if got := receive(); !slices.Equal(got, want) {
+		t.Fatalf("unexpected metrics after increment: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	log "log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	tagsFileExt = ".tags"  // Tags sidecar file extension.
+	maxTagsBody = 16 << 10 // Limit for PUT tags bodies in bytes.
+	maxTags     = 64       // Maximum number of tags of a state.
+)
+
+var ErrInvalidTags = errors.New("invalid tags")
+
+// parseTags parses and validates the tags of a state, a JSON object of strings.
+func parseTags(data []byte) (map[string]string, error) {
+	var tags map[string]string
+
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTags, err)
+	}
+
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("%w: %d tags, at most %d allowed", ErrInvalidTags, len(tags), maxTags)
+	}
+
+	for key := range tags {
+		if key == "" || strings.Contains(key, "=") {
+			return nil, fmt.Errorf("%w: key %q must be non-empty and not contain =", ErrInvalidTags, key)
+		}
+	}
+
+	return tags, nil
+}
+
+// readTags retrieves the tags of the state, empty if it has none.
+func (s *Storage) readTags(name string) (map[string]string, error) {
+	data, err := os.ReadFile(filepath.Join(s.path, name+tagsFileExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tags: %w", err)
+	}
+
+	tags, err := parseTags(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: tags of %s: %w", ErrCorrupt, name, err)
+	}
+
+	return tags, nil
+}
+
+// Tagged returns the states having the tag with the value.
+func (s States) Tagged(key, value string) States {
+	tagged := States{}
+
+	for _, state := range s {
+		if v, ok := state.Tags[key]; ok && v == value {
+			tagged = append(tagged, state)
+		}
+	}
+
+	return tagged
+}
+
+// filterTags returns the states having all tags of the tag query parameters, given as key=value.
+func filterTags(states States, filters []string) (States, error) {
+	for _, filter := range filters {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%w: filter %q must be key=value", ErrInvalidTags, filter)
+		}
+
+		states = states.Tagged(key, value)
+	}
+
+	return states, nil
+}
+
+// writeTags replaces the tags of the state.
+func (s *Storage) writeTags(name string, tags map[string]string) error {
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return fmt.Errorf("failed to encode tags: %w", err)
+	}
+
+	return s.writeFile(filepath.Join(s.path, name+tagsFileExt), data)
+}
+
+// checkExists responds with 404 if the state doesn't exist.
+// Returns true if it does.
+func (s *Storage) checkExists(w http.ResponseWriter, name string) bool {
+	exists, err := s.exists(name)
+	if err != nil {
+		writeError(w, "failed to check state", name, err)
+
+		return false
+	}
+
+	if !exists {
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+
+	return exists
+}
+
+// handleGetTags is HTTP handler for GET /{name}/tags.
+func (s *Storage) handleGetTags(w http.ResponseWriter, _ *http.Request, name string) {
+	if !s.checkExists(w, name) {
+		return
+	}
+
+	tags, err := s.readTags(name)
+	if err != nil {
+		writeError(w, "failed to read tags", name, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(tags); err != nil {
+		log.Error("failed to encode JSON:", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// handlePutTags is HTTP handler for PUT /{name}/tags.
+// It replaces the tags of the state with the JSON object of the body, kept apart from the state content.
+func (s *Storage) handlePutTags(w http.ResponseWriter, r *http.Request, name string) {
+	defer r.Body.Close()
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxTagsBody))
+	if maxErr := new(http.MaxBytesError); errors.As(err, &maxErr) {
+		log.Warn("tags too large", "name", name, "limit", maxErr.Limit)
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	if err != nil {
+		log.Error("failed to read request body", "name", name, "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+
+		return
+	}
+
+	tags, err := parseTags(data)
+	if err != nil {
+		log.Warn("invalid tags", "name", name, "error", err)
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if !s.checkExists(w, name) {
+		return
+	}
+
+	if err := s.writeTags(name, tags); err != nil {
+		writeError(w, "failed to write tags", name, err)
+
+		return
+	}
+
+	audit(r, "state tagged", "name", name)
+}
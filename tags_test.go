@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func putTestTags(t *testing.T, storage *Storage, state, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPut, "/"+state+"/tags", bytes.NewBufferString(body))
+	req.SetPathValue("name", state)
+	req.SetPathValue("action", "tags")
+
+	w := httptest.NewRecorder()
+
+	storage.handleAction(w, req)
+
+	return w
+}
+
+func TestStorageTags(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	filePath := filepath.Join(storage.path, name+stateFileExt)
+
+	if w := putTestTags(t, storage, name, `{"env":"prod"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code for missing state: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	writeTestFile(t, filePath, "content")
+
+	w := doAction(t, storage, http.MethodGet, name, "tags")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "{}" {
+		t.Fatalf("unexpected response for untagged state: %d %q", w.Code, w.Body.String())
+	}
+
+	invalid := []string{`not json`, `["env"]`, `{"env":1}`, `{"":"x"}`, `{"a=b":"x"}`}
+	for _, body := range invalid {
+		if w := putTestTags(t, storage, name, body); w.Code != http.StatusBadRequest {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	large := `{"k":"` + strings.Repeat("x", maxTagsBody) + `"}`
+	if w := putTestTags(t, storage, name, large); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status code for large tags: got %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	if w := putTestTags(t, storage, name, `{"env":"prod","owner":"platform"}`); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// Tags survive state updates.
+	post := httptest.NewRecorder()
+	storage.handlePost(post, httptest.NewRequest(http.MethodPost, "/"+name, bytes.NewBufferString("updated")), name)
+
+	var tags map[string]string
+
+	if err := json.NewDecoder(doAction(t, storage, http.MethodGet, name, "tags").Body).Decode(&tags); err != nil {
+		t.Fatalf("failed to decode tags: %v", err)
+	}
+
+	if tags["env"] != "prod" || tags["owner"] != "platform" || len(tags) != 2 {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+
+	if w := doTargetAction(t, storage, "move", name, "moved"); w.Code != http.StatusCreated {
+		t.Fatalf("unexpected status code for move: got %d, want %d", w.Code, http.StatusCreated)
+	}
+
+	if tags, err := storage.readTags("moved"); err != nil || tags["env"] != "prod" {
+		t.Fatalf("tags not moved: %v, %v", tags, err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/moved", nil)
+	storage.handleDelete(httptest.NewRecorder(), req, "moved")
+
+	if _, err := os.Stat(filepath.Join(storage.path, "moved"+tagsFileExt)); !os.IsNotExist(err) {
+		t.Fatalf("tags not deleted with the state: %v", err)
+	}
+}
+
+func TestStorageAllStatesTagFilter(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	for state, tags := range map[string]string{
+		"a": `{"env":"prod","owner":"platform"}`,
+		"b": `{"env":"prod"}`,
+		"c": `{"env":"dev"}`,
+		"d": "",
+	} {
+		writeTestFile(t, filepath.Join(storage.path, state+stateFileExt), "content")
+
+		if tags != "" {
+			writeTestFile(t, filepath.Join(storage.path, state+tagsFileExt), tags)
+		}
+	}
+
+	// Tags of a deleted state are ignored.
+	writeTestFile(t, filepath.Join(storage.path, "gone"+tagsFileExt), `{"env":"prod"}`)
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/", []string{"a", "b", "c", "d"}},
+		{"/?tag=env=prod", []string{"a", "b"}},
+		{"/?tag=env=prod&tag=owner=platform", []string{"a"}},
+		{"/?tag=env=staging", []string{}},
+	}
+
+	for _, tt := range tests {
+		states := listTestStates(t, storage, tt.target)
+
+		names := []string{}
+		for _, state := range states {
+			names = append(names, state.Name)
+		}
+
+		if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+			t.Fatalf("unexpected states for %s: got %v, want %v", tt.target, names, tt.want)
+		}
+	}
+
+	if states := listTestStates(t, storage, "/?tag=owner=platform"); states[0].Tags["env"] != "prod" {
+		t.Fatalf("tags not listed: %v", states[0].Tags)
+	}
+
+	w := httptest.NewRecorder()
+	storage.allStates(w, httptest.NewRequest(http.MethodGet, "/?tag=env", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -1,17 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
+	stdlog "log"
 	log "log/slog"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,16 +31,42 @@ const (
 	testFileName       = "test_rw"            // File name for read/write permission check.
 	stateFileExt       = ".tfstate"           // Terraform state file extension.
 	lockFileExt        = ".lock"              // Lock file extension.
+	tmpFileExt         = ".tmp"               // Temporary file extension used by atomic writes.
+	maxLoggedMethods   = 64                   // Maximum number of distinct unknown methods remembered.
+	defaultMaxLockBody = 64 << 10             // Default limit for LOCK and UNLOCK request bodies in bytes.
 	defaultFileMode    = 0o644                // Default permission for files
 	defaultDirMode     = 0o755                // Default permission for directory
+	defaultLogFormat   = logFormatText        // Default log output format.
+)
+
+// Supported orders of the state listing.
+const (
+	sortName        = "name"         // By name, ascending.
+	sortNameDesc    = "name-desc"    // By name, descending.
+	sortUpdated     = "updated"      // Least recently modified first.
+	sortUpdatedDesc = "updated-desc" // Most recently modified first.
+)
+
+// Supported log output formats.
+const (
+	logFormatText   = "text"   // Human-readable lines of the standard logger.
+	logFormatJSON   = "json"   // One JSON object per line.
+	logFormatLogfmt = "logfmt" // Plain key=value pairs.
 )
 
 var (
-	ErrNotDirectory    = errors.New("is not directory")
-	ErrAlreadyLocked   = errors.New("state already locked")
-	ErrAlreadyUnlocked = errors.New("state already unlocked")
-	ErrAlreadyExists   = errors.New("state already exists")
-	ErrNotExists       = errors.New("state does not exists")
+	ErrNotDirectory     = errors.New("is not directory")
+	ErrAlreadyLocked    = errors.New("state already locked")
+	ErrAlreadyUnlocked  = errors.New("state already unlocked")
+	ErrAlreadyExists    = errors.New("state already exists")
+	ErrNotExists        = errors.New("state does not exists")
+	ErrInvalidLogFormat = errors.New("invalid log format")
+	ErrInconsistent     = errors.New("inconsistent storage")
+	ErrInvalidName      = errors.New("invalid state name")
+	ErrReservedName     = errors.New("state name reserved for a server endpoint")
+	ErrInvalidSort      = errors.New("invalid sort order")
+	ErrEmptyState       = errors.New("empty state")
+	ErrBlankState       = errors.New("whitespace-only state")
 )
 
 // stringFromEnv retrieves the value of the environment variable named by the `key`.
@@ -58,11 +94,89 @@ func boolFromEnv(key string, def bool) bool {
 	return def
 }
 
+// int64FromEnv retrieves the value of the environment variable named by the `key`.
+// It returns the integer value of the variable if present and valid.
+// Otherwise, it returns the default value `def`.
+func int64FromEnv(key string, def int64) int64 {
+	if v := os.Getenv(key); v != "" {
+		parsed, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err == nil {
+			return parsed
+		}
+	}
+
+	return def
+}
+
+// durationFromEnv retrieves the value of the environment variable named by the `key`.
+// It returns the duration value of the variable if present and valid.
+// Otherwise, it returns the default value `def`.
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		parsed, err := time.ParseDuration(strings.TrimSpace(v))
+		if err == nil {
+			return parsed
+		}
+	}
+
+	return def
+}
+
+// linesFromEnv retrieves the value of the environment variable named by the `key`.
+// It returns the non-empty lines of the value if variable present.
+// Otherwise, it returns nil.
+func linesFromEnv(key string) []string {
+	var lines []string
+
+	for _, line := range strings.Split(os.Getenv(key), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
 // Flags represents a command line parameters.
 type Flags struct {
-	addr  string // The address to which HTTP server will bind.
-	path  string // The path to Terraform state files storage.
-	debug bool   // Enables debug mode.
+	addr      string        // The address to which HTTP server will bind.
+	path      string        // The path to Terraform state files storage.
+	debug     bool          // Enables debug mode.
+	logFormat string        // The log output format.
+	resumable bool          // Enables resumable uploads via Content-Range.
+	pattern   string        // The regular expression all state names must match.
+	seedDir   string        // The directory with states to seed the storage from.
+	admin     string        // The bearer token required by admin endpoints.
+	stale     time.Duration // The age after which a state is listed as stale.
+	lockBody  int64         // The maximum size of LOCK and UNLOCK request bodies.
+	fallback  string        // The directory states missing from the storage are read from.
+	populate  bool          // Copy states read from the fallback into the storage.
+	noFsync   bool          // Skips fsync when writing states.
+	release   bool          // Releases locks when the connection that acquired them closes.
+	compress  string        // The content codings GET responses are compressed with, in preference order.
+	minFree   int64         // The free space in bytes POST must leave on the storage file system.
+	unlockAll string        // The confirmation token of the unlock-all admin endpoint.
+	sort      string        // The order of the state listing unless requested otherwise.
+	accessLog string        // The format of the access log written to stdout.
+	connsIP   int64         // The maximum number of concurrent connections per source IP.
+	statsd    string        // The address of the StatsD server metrics are pushed to.
+	shrink    int64         // The percentage by which POST may shrink a state.
+	reusePort bool          // Binds the address with SO_REUSEPORT.
+	routes    bool          // Prints the registered routes on startup.
+	lockLife  time.Duration // The time after which a lock is force-released.
+	defName   string        // The state requests to / operate on instead of listing the states.
+	tlsCert   string        // The TLS certificate file, serving HTTPS if set.
+	tlsKey    string        // The TLS private key file.
+	tlsHosts  string        // The comma separated hostnames TLS requests are accepted for.
+	maxState  int64         // The maximum size of states written in bytes.
+	allowZero bool          // Accepts empty and whitespace-only states.
+	signing   string        // The shared secret mutating requests must be signed with.
+	headers   []string      // The headers added to every response, as "Name: value".
+	lockRetry time.Duration // The base Retry-After of LOCK requests for locked states.
+	mStates   int64         // The maximum number of states with their own label in per state metrics.
+	safeMode  bool          // Starts with write requests rejected unless they carry the admin token.
+	maxLocks  int64         // The maximum number of locked states.
+	redact    string        // The pattern of the state name parts masked in logs and metrics.
 }
 
 // parseFlags retrieves the parsed command line parameters.
@@ -78,37 +192,396 @@ Overrides the TF_HTTP_PATH environment variable if set.
 Default = /var/lib/terraform
 	`
 	debugHelpText := `
-Enables debug mode.
+Enables debug mode: debug logging and diagnostic endpoints such as GET /{name}/lock.
 Overrides the TF_HTTP_DEBUG environment variable if set.
 Default = false
 	`
+	logFormatHelpText := `
+The log output format: text, json or logfmt.
+Overrides the TF_HTTP_LOG_FORMAT environment variable if set.
+Default = text
+	`
+	resumableHelpText := `
+Enables resumable chunked uploads of states via the Content-Range header.
+Overrides the TF_HTTP_ENABLE_RESUMABLE environment variable if set.
+Default = false
+	`
+	patternHelpText := `
+The regular expression all state names must match, e.g. ^[a-z0-9-]+_[a-z0-9-]+$ for team_workspace names.
+A name is a single path segment, '/' is always rejected and never seen by the pattern.
+Overrides the TF_HTTP_NAME_PATTERN environment variable if set.
+Default = any name
+	`
+	seedDirHelpText := `
+The directory with state files copied into the storage on startup.
+States already present in the storage are left untouched.
+Overrides the TF_HTTP_SEED_DIR environment variable if set.
+Default = no seeding
+	`
+	adminHelpText := `
+The bearer token required by admin endpoints under /admin/.
+Admin endpoints are disabled unless set.
+Overrides the TF_HTTP_ADMIN_TOKEN environment variable if set.
+Default = disabled
+	`
+	staleHelpText := `
+The age after which a state not updated is marked as stale in the listing, e.g. 2160h.
+Can be overridden per request with the stale-after query parameter.
+Overrides the TF_HTTP_STALE_AFTER environment variable if set.
+Default = disabled
+	`
+	lockBodyHelpText := `
+The maximum size of LOCK and UNLOCK request bodies in bytes.
+Overrides the TF_HTTP_MAX_LOCK_BODY environment variable if set.
+Default = 65536
+	`
+	fallbackHelpText := `
+The path to a secondary state storage read when a state is missing from the storage.
+Overrides the TF_HTTP_READ_FALLBACK_BACKEND environment variable if set.
+Default = disabled
+	`
+	populateHelpText := `
+Copies states read from the fallback storage into the storage.
+Overrides the TF_HTTP_READ_FALLBACK_POPULATE environment variable if set.
+Default = false
+	`
+	noFsyncHelpText := `
+Skips flushing written states to disk before they replace the previous version.
+Speeds up writes, but a power failure or kernel crash may lose or corrupt recently written states.
+Only use it where states are disposable, e.g. ephemeral CI environments.
+Overrides the TF_HTTP_NO_FSYNC environment variable if set.
+Default = false
+	`
+	releaseHelpText := `
+Releases a lock when the connection the LOCK request arrived on closes.
+Only useful for clients holding a persistent connection while locked, standard Terraform doesn't:
+its idle connections are closed after a minute, releasing the lock in the middle of a run.
+Overrides the TF_HTTP_RELEASE_LOCKS_ON_DISCONNECT environment variable if set.
+Default = false
+	`
+	compressHelpText := `
+The comma separated content codings GET responses are compressed with, in order of preference: gzip, deflate.
+The coding the client prefers most in Accept-Encoding is used, ties are broken by this order.
+Set to identity to disable compression.
+Overrides the TF_HTTP_COMPRESSION_ALGOS environment variable if set.
+Default = gzip,deflate
+	`
+	minFreeHelpText := `
+The free space in bytes a POST must leave on the storage file system, rejected with 507 otherwise.
+Only available on Linux, macOS and FreeBSD.
+Overrides the TF_HTTP_MIN_FREE_SPACE environment variable if set.
+Default = 0 (disabled)
+	`
+	unlockAllHelpText := `
+The confirmation token POST /admin/unlock-all must be called with as the confirm query parameter.
+The endpoint clearing every lock is disabled unless both this and the admin token are set.
+Overrides the TF_HTTP_UNLOCK_ALL_TOKEN environment variable if set.
+Default = disabled
+	`
+	sortHelpText := `
+The order of the state listing unless requested with the sort query parameter:
+name, name-desc, updated or updated-desc (most recently modified first).
+Overrides the TF_HTTP_DEFAULT_SORT environment variable if set.
+Default = name
+	`
+	accessLogHelpText := `
+Writes an access log line per request to stdout in the given format:
+clf (Apache Common Log Format) or combined (Apache Combined Log Format).
+Overrides the TF_HTTP_ACCESS_LOG_FORMAT environment variable if set.
+Default = disabled
+	`
+	connsIPHelpText := `
+The maximum number of concurrent connections per source IP, further connections are closed right away.
+The source is the peer address of the connection, behind a proxy that is the proxy.
+Overrides the TF_HTTP_MAX_CONNS_PER_IP environment variable if set.
+Default = 0 (unlimited)
+	`
+	statsdHelpText := `
+The address of a StatsD server the metrics are pushed to over UDP every 10 seconds, e.g. localhost:8125.
+Labels are sent as DogStatsD tags. The Prometheus /metrics endpoint stays available.
+Overrides the TF_HTTP_STATSD_ADDR environment variable if set.
+Default = disabled
+	`
+	shrinkHelpText := `
+The percentage by which a POST may shrink a state, in resource instances or in bytes for states without them.
+Larger shrinks are rejected with 409 unless the request has the allow-shrink=true query parameter.
+Overrides the TF_HTTP_SHRINK_THRESHOLD environment variable if set.
+Default = 0 (disabled)
+	`
+	reusePortHelpText := `
+Binds the address with SO_REUSEPORT, so a new instance can start listening before the old one exits.
+Only available on Linux and the BSDs, including macOS.
+Overrides the TF_HTTP_REUSEPORT environment variable if set.
+Default = false
+	`
+	routesHelpText := `
+Prints every registered route with its handler to stdout on startup, after all options are applied.
+The routes are logged at debug level regardless.
+Overrides the TF_HTTP_PRINT_ROUTES environment variable if set.
+Default = false
+	`
+	lockLifeHelpText := `
+The maximum time a lock is held from its acquisition, e.g. 2h.
+An older lock is force-released on the next request to its state and the release is logged.
+Overrides the TF_HTTP_LOCK_MAX_LIFETIME environment variable if set.
+Default = unlimited
+	`
+	defNameHelpText := `
+The state requests to / operate on instead of listing the states, for single-workspace setups.
+The state is also available at /<name>.
+Overrides the TF_HTTP_DEFAULT_NAME environment variable if set.
+Default = none, requests to / list the states
+	`
+	tlsCertHelpText := `
+The PEM encoded TLS certificate file, serving HTTPS instead of HTTP if set. Requires -tls-key.
+Overrides the TF_HTTP_TLS_CERT environment variable if set.
+Default = disabled
+	`
+	tlsKeyHelpText := `
+The PEM encoded TLS private key file of the certificate.
+Overrides the TF_HTTP_TLS_KEY environment variable if set.
+Default = none
+	`
+	tlsHostsHelpText := `
+The comma separated hostnames the server answers for over TLS, e.g. tf.example.com,*.tf.example.com.
+Handshakes for other SNI hostnames fail and requests for other Host headers are rejected with 421.
+Overrides the TF_HTTP_TLS_ALLOWED_HOSTS environment variable if set.
+Default = all hostnames
+	`
+	maxStateHelpText := `
+The maximum size in bytes of states written by POST or imported, larger ones are rejected with 413.
+Overrides the TF_HTTP_MAX_STATE_SIZE environment variable if set.
+Default = 0 (unlimited)
+	`
+	allowZeroHelpText := `
+Accepts empty and whitespace-only states written by POST or imported, rejected with 400 otherwise.
+Overrides the TF_HTTP_ALLOW_EMPTY_STATE environment variable if set.
+Default = false
+	`
+	signingHelpText := `
+The shared secret requests other than GET and HEAD must be signed with, rejected with 401 otherwise.
+Signatures are only valid for 5 minutes around the server time and each nonce only once.
+Overrides the TF_HTTP_SIGNING_SECRET environment variable if set.
+Default = disabled
+	`
+	headersHelpText := `
+A header added to every response as "Name: value", e.g. "X-Content-Type-Options: nosniff".
+Repeat the flag to add several. Headers set by the server for the response take precedence.
+Overrides the TF_HTTP_RESPONSE_HEADERS environment variable, one header per line, if set.
+Default = none
+	`
+	lockRetryHelpText := `
+Answers LOCK requests for a locked state with 429 and a Retry-After between once and twice this duration,
+instead of 423, so clients retrying on 429 back off. Once retries run out they report a generic error.
+Overrides the TF_HTTP_LOCK_RETRY_AFTER environment variable if set.
+Default = disabled
+	`
+	mStatesHelpText := `
+The maximum number of states with their own name label in per state metrics such as
+terraform_backend_writes_total. States beyond it are counted under the name "_other".
+Overrides the TF_HTTP_METRICS_MAX_STATES environment variable if set.
+Default = 100, 0 is unlimited
+	`
+	safeModeHelpText := `
+Starts in safe mode: write requests are rejected with 503 to freeze the states, except those carrying
+the admin token in the X-Safe-Mode-Token header, which are audit-logged. Reads are served as usual.
+Safe mode is toggled without restart by SIGUSR1 or POST /admin/safe-mode?enabled=true|false.
+Overrides the TF_HTTP_SAFE_MODE environment variable if set.
+Default = false
+	`
+	maxLocksHelpText := `
+The maximum number of states locked at the same time. Once reached, LOCK requests are rejected
+with 503 until some locks are released, bounding the damage of runaway automation.
+Overrides the TF_HTTP_MAX_CONCURRENT_LOCKS environment variable if set.
+Default = 0 (unlimited)
+	`
+	redactHelpText := `
+A regular expression matched against state names, whose matches in logs, the access log included, and in
+metric labels are replaced with a keyed hash, for state names embedding sensitive data such as customer IDs.
+States are still stored under their real names.
+Overrides the TF_HTTP_REDACT_NAME_PATTERN environment variable if set.
+Default = no redaction
+	`
 
 	flags := &Flags{
-		addr:  stringFromEnv("TF_HTTP_ADDR", defaultListenAddr),
-		path:  stringFromEnv("TF_HTTP_PATH", defaultStoragePath),
-		debug: boolFromEnv("TF_HTTP_DEBUG", false),
+		addr:      stringFromEnv("TF_HTTP_ADDR", defaultListenAddr),
+		path:      stringFromEnv("TF_HTTP_PATH", defaultStoragePath),
+		debug:     boolFromEnv("TF_HTTP_DEBUG", false),
+		logFormat: stringFromEnv("TF_HTTP_LOG_FORMAT", defaultLogFormat),
+		resumable: boolFromEnv("TF_HTTP_ENABLE_RESUMABLE", false),
+		pattern:   stringFromEnv("TF_HTTP_NAME_PATTERN", ""),
+		seedDir:   stringFromEnv("TF_HTTP_SEED_DIR", ""),
+		admin:     stringFromEnv("TF_HTTP_ADMIN_TOKEN", ""),
+		stale:     durationFromEnv("TF_HTTP_STALE_AFTER", 0),
+		lockBody:  int64FromEnv("TF_HTTP_MAX_LOCK_BODY", defaultMaxLockBody),
+		fallback:  stringFromEnv("TF_HTTP_READ_FALLBACK_BACKEND", ""),
+		populate:  boolFromEnv("TF_HTTP_READ_FALLBACK_POPULATE", false),
+		noFsync:   boolFromEnv("TF_HTTP_NO_FSYNC", false),
+		release:   boolFromEnv("TF_HTTP_RELEASE_LOCKS_ON_DISCONNECT", false),
+		compress:  stringFromEnv("TF_HTTP_COMPRESSION_ALGOS", defaultCompressionAlgos),
+		minFree:   int64FromEnv("TF_HTTP_MIN_FREE_SPACE", 0),
+		unlockAll: stringFromEnv("TF_HTTP_UNLOCK_ALL_TOKEN", ""),
+		sort:      stringFromEnv("TF_HTTP_DEFAULT_SORT", sortName),
+		accessLog: stringFromEnv("TF_HTTP_ACCESS_LOG_FORMAT", ""),
+		connsIP:   int64FromEnv("TF_HTTP_MAX_CONNS_PER_IP", 0),
+		statsd:    stringFromEnv("TF_HTTP_STATSD_ADDR", ""),
+		shrink:    int64FromEnv("TF_HTTP_SHRINK_THRESHOLD", 0),
+		reusePort: boolFromEnv("TF_HTTP_REUSEPORT", false),
+		routes:    boolFromEnv("TF_HTTP_PRINT_ROUTES", false),
+		lockLife:  durationFromEnv("TF_HTTP_LOCK_MAX_LIFETIME", 0),
+		defName:   stringFromEnv("TF_HTTP_DEFAULT_NAME", ""),
+		tlsCert:   stringFromEnv("TF_HTTP_TLS_CERT", ""),
+		tlsKey:    stringFromEnv("TF_HTTP_TLS_KEY", ""),
+		tlsHosts:  stringFromEnv("TF_HTTP_TLS_ALLOWED_HOSTS", ""),
+		maxState:  int64FromEnv("TF_HTTP_MAX_STATE_SIZE", 0),
+		allowZero: boolFromEnv("TF_HTTP_ALLOW_EMPTY_STATE", false),
+		signing:   stringFromEnv("TF_HTTP_SIGNING_SECRET", ""),
+		headers:   linesFromEnv("TF_HTTP_RESPONSE_HEADERS"),
+		lockRetry: durationFromEnv("TF_HTTP_LOCK_RETRY_AFTER", 0),
+		mStates:   int64FromEnv("TF_HTTP_METRICS_MAX_STATES", defaultMetricsMaxStates),
+		safeMode:  boolFromEnv("TF_HTTP_SAFE_MODE", false),
+		maxLocks:  int64FromEnv("TF_HTTP_MAX_CONCURRENT_LOCKS", 0),
+		redact:    stringFromEnv("TF_HTTP_REDACT_NAME_PATTERN", ""),
 	}
 
 	flag.StringVar(&flags.addr, "address", flags.addr, strings.TrimSpace(addrHelpText))
 	flag.StringVar(&flags.path, "path", flags.path, strings.TrimSpace(pathHelpText))
 	flag.BoolVar(&flags.debug, "debug", flags.debug, strings.TrimSpace(debugHelpText))
+	flag.StringVar(&flags.logFormat, "log-format", flags.logFormat, strings.TrimSpace(logFormatHelpText))
+	flag.BoolVar(&flags.resumable, "enable-resumable", flags.resumable, strings.TrimSpace(resumableHelpText))
+	flag.StringVar(&flags.pattern, "name-pattern", flags.pattern, strings.TrimSpace(patternHelpText))
+	flag.StringVar(&flags.seedDir, "seed-dir", flags.seedDir, strings.TrimSpace(seedDirHelpText))
+	flag.StringVar(&flags.admin, "admin-token", flags.admin, strings.TrimSpace(adminHelpText))
+	flag.DurationVar(&flags.stale, "stale-after", flags.stale, strings.TrimSpace(staleHelpText))
+	flag.Int64Var(&flags.lockBody, "max-lock-body", flags.lockBody, strings.TrimSpace(lockBodyHelpText))
+	flag.StringVar(&flags.fallback, "read-fallback-backend", flags.fallback, strings.TrimSpace(fallbackHelpText))
+	flag.BoolVar(&flags.populate, "read-fallback-populate", flags.populate, strings.TrimSpace(populateHelpText))
+	flag.BoolVar(&flags.noFsync, "no-fsync", flags.noFsync, strings.TrimSpace(noFsyncHelpText))
+	flag.BoolVar(&flags.release, "release-locks-on-disconnect", flags.release, strings.TrimSpace(releaseHelpText))
+	flag.StringVar(&flags.compress, "compression-algos", flags.compress, strings.TrimSpace(compressHelpText))
+	flag.Int64Var(&flags.minFree, "min-free-space", flags.minFree, strings.TrimSpace(minFreeHelpText))
+	flag.StringVar(&flags.unlockAll, "unlock-all-token", flags.unlockAll, strings.TrimSpace(unlockAllHelpText))
+	flag.StringVar(&flags.sort, "default-sort", flags.sort, strings.TrimSpace(sortHelpText))
+	flag.StringVar(&flags.accessLog, "access-log-format", flags.accessLog, strings.TrimSpace(accessLogHelpText))
+	flag.Int64Var(&flags.connsIP, "max-conns-per-ip", flags.connsIP, strings.TrimSpace(connsIPHelpText))
+	flag.StringVar(&flags.statsd, "statsd-addr", flags.statsd, strings.TrimSpace(statsdHelpText))
+	flag.Int64Var(&flags.shrink, "shrink-threshold", flags.shrink, strings.TrimSpace(shrinkHelpText))
+	flag.BoolVar(&flags.reusePort, "reuseport", flags.reusePort, strings.TrimSpace(reusePortHelpText))
+	flag.BoolVar(&flags.routes, "print-routes", flags.routes, strings.TrimSpace(routesHelpText))
+	flag.DurationVar(&flags.lockLife, "lock-max-lifetime", flags.lockLife, strings.TrimSpace(lockLifeHelpText))
+	flag.StringVar(&flags.defName, "default-name", flags.defName, strings.TrimSpace(defNameHelpText))
+	flag.StringVar(&flags.tlsCert, "tls-cert", flags.tlsCert, strings.TrimSpace(tlsCertHelpText))
+	flag.StringVar(&flags.tlsKey, "tls-key", flags.tlsKey, strings.TrimSpace(tlsKeyHelpText))
+	flag.StringVar(&flags.tlsHosts, "tls-allowed-hosts", flags.tlsHosts, strings.TrimSpace(tlsHostsHelpText))
+	flag.Int64Var(&flags.maxState, "max-state-size", flags.maxState, strings.TrimSpace(maxStateHelpText))
+	flag.BoolVar(&flags.allowZero, "allow-empty-state", flags.allowZero, strings.TrimSpace(allowZeroHelpText))
+	flag.StringVar(&flags.signing, "signing-secret", flags.signing, strings.TrimSpace(signingHelpText))
+	flag.DurationVar(&flags.lockRetry, "lock-retry-after", flags.lockRetry, strings.TrimSpace(lockRetryHelpText))
+	flag.Int64Var(&flags.mStates, "metrics-max-states", flags.mStates, strings.TrimSpace(mStatesHelpText))
+	flag.BoolVar(&flags.safeMode, "safe-mode", flags.safeMode, strings.TrimSpace(safeModeHelpText))
+	flag.Int64Var(&flags.maxLocks, "max-concurrent-locks", flags.maxLocks, strings.TrimSpace(maxLocksHelpText))
+	flag.StringVar(&flags.redact, "redact-name-pattern", flags.redact, strings.TrimSpace(redactHelpText))
+
+	headersSet := false
+
+	flag.Func("response-header", strings.TrimSpace(headersHelpText), func(v string) error {
+		if !headersSet {
+			flags.headers, headersSet = nil, true
+		}
+
+		flags.headers = append(flags.headers, v)
+
+		return nil
+	})
 	flag.Parse()
 
 	return flags
 }
 
-// setupLogging enables logging debug mode.
-func setupLogging(debug bool) {
+// lowercaseLevel renders the level attribute in lower case as is customary for logfmt.
+func lowercaseLevel(_ []string, a log.Attr) log.Attr {
+	if a.Key == log.LevelKey {
+		return log.String(log.LevelKey, strings.ToLower(a.Value.String()))
+	}
+
+	return a
+}
+
+// newLogHandler retrieves a structured log handler for given format writing to out.
+func newLogHandler(format string, level log.Leveler, out io.Writer) (log.Handler, error) {
+	switch format {
+	case logFormatJSON:
+		return log.NewJSONHandler(out, &log.HandlerOptions{Level: level}), nil
+	case logFormatLogfmt:
+		return log.NewTextHandler(out, &log.HandlerOptions{Level: level, ReplaceAttr: lowercaseLevel}), nil
+	default:
+		return nil, fmt.Errorf("%w %q: allowed formats are %s, %s, %s",
+			ErrInvalidLogFormat, format, logFormatText, logFormatJSON, logFormatLogfmt)
+	}
+}
+
+// setLogLevel changes the minimum level of the default logger.
+func setLogLevel(lv *log.LevelVar, level log.Level) {
+	lv.Set(level)
+	log.SetLogLoggerLevel(level)
+}
+
+// toggleDebug flips the log level between the configured level and debug.
+// It returns the new level.
+func toggleDebug(lv *log.LevelVar, configured log.Level) log.Level {
+	next := log.LevelDebug
+
+	if lv.Level() == log.LevelDebug {
+		next = configured
+		if configured == log.LevelDebug {
+			next = log.LevelInfo
+		}
+	}
+
+	setLogLevel(lv, next)
+
+	return next
+}
+
+// setupLogging configures the default logger output format and writer and enables logging debug mode.
+// State names are masked with the redactor, if set.
+func setupLogging(format string, debug bool, lv *log.LevelVar, out io.Writer, nr *nameRedactor) error {
+	handler := log.Default().Handler()
+
+	if format == logFormatText {
+		// The default handler writes through the standard logger.
+		stdlog.SetOutput(out)
+	} else {
+		var err error
+		if handler, err = newLogHandler(format, lv, out); err != nil {
+			return err
+		}
+	}
+
+	log.SetDefault(log.New(nr.handler(handler)))
+
+	if format == logFormatText {
+		// Setting a wrapped default handler redirects the standard logger to it, which it writes through.
+		stdlog.SetOutput(out)
+		stdlog.SetFlags(stdlog.LstdFlags)
+	}
+
 	if debug {
-		log.SetLogLoggerLevel(log.LevelDebug)
+		setLogLevel(lv, log.LevelDebug)
 		log.Debug("debug mode on")
 	}
+
+	return nil
 }
 
 // State represents Terraform state file.
 type State struct {
-	Name   string `json:"name"`
-	Locked bool   `json:"locked"`
+	Name    string    `json:"name"`
+	Locked  bool      `json:"locked"`
+	Updated time.Time `json:"updated"`
+	Stale   bool      `json:"stale,omitempty"`
+
+	Tags map[string]string `json:"tags,omitempty"`
 }
 
 // IsLocked returns true if state locked.
@@ -156,12 +629,12 @@ func (s *States) State(name string) (*State, bool) {
 
 // Adds a state to the list.
 // Returns an error if a state with the same name already exists.
-func (s *States) Add(name string) error {
+func (s *States) Add(name string, updated time.Time) error {
 	if _, exists := s.State(name); exists {
 		return ErrAlreadyExists
 	}
 
-	*s = append(*s, &State{Name: name, Locked: false})
+	*s = append(*s, &State{Name: name, Locked: false, Updated: updated})
 
 	return nil
 }
@@ -177,15 +650,100 @@ func (s *States) Lock(name string) error {
 	return state.Lock()
 }
 
-func processEntries(entries []os.DirEntry, ext string, action func(name string) error) error {
+// Marks states last updated before given time as stale.
+func (s *States) MarkStale(before time.Time) {
+	for _, state := range *s {
+		state.Stale = state.Updated.Before(before)
+	}
+}
+
+// Sorts states in the given order.
+// Returns an error if the order is not supported.
+func (s *States) Sort(order string) error {
+	byName := func(a, b *State) int { return strings.Compare(a.Name, b.Name) }
+	byUpdated := func(a, b *State) int { return a.Updated.Compare(b.Updated) }
+	desc := func(cmp func(a, b *State) int) func(a, b *State) int {
+		return func(a, b *State) int { return cmp(b, a) }
+	}
+
+	cmp, ok := map[string]func(a, b *State) int{
+		sortName:        byName,
+		sortNameDesc:    desc(byName),
+		sortUpdated:     byUpdated,
+		sortUpdatedDesc: desc(byUpdated),
+	}[order]
+	if !ok {
+		return fmt.Errorf("%w %q: allowed orders are %s, %s, %s, %s",
+			ErrInvalidSort, order, sortName, sortNameDesc, sortUpdated, sortUpdatedDesc)
+	}
+
+	slices.SortStableFunc(*s, cmp)
+
+	return nil
+}
+
+// isWorkFile reports whether the file is a temporary or partial file of a write in progress.
+func isWorkFile(file string) bool {
+	return strings.HasSuffix(file, tmpFileExt) || strings.HasSuffix(file, partFileExt)
+}
+
+// entryInfo retrieves the information of a directory entry, following symlinks.
+// Returns nil if the entry is a symlink whose target is missing or not a regular file.
+func entryInfo(dir string, e os.DirEntry) (fs.FileInfo, error) {
+	if e.Type()&fs.ModeSymlink == 0 {
+		if !e.Type().IsRegular() {
+			return nil, unexpectedFileType(e.Name(), e.Type())
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve information for %s: %w", e.Name(), err)
+		}
+
+		return info, nil
+	}
+
+	info, err := os.Stat(filepath.Join(dir, e.Name()))
+	if err != nil {
+		log.Warn("skipping symlink to missing file", "file", e.Name(), "error", err)
+
+		return nil, nil //nolint:nilnil // A dangling symlink is skipped, not an error.
+	}
+
+	if !info.Mode().IsRegular() {
+		log.Warn("skipping symlink to non-regular file", "file", e.Name(), "type", info.Mode().Type().String())
+
+		return nil, nil //nolint:nilnil // A symlink to a directory or device is skipped, not an error.
+	}
+
+	return info, nil
+}
+
+// entryAction processes the file of a state, given its name and the information of the file.
+type entryAction func(name string, info fs.FileInfo) error
+
+// processEntries calls action for the entries of dir with the extension.
+// Files of writes in progress are never processed, whatever the extension,
+// nor are symlinks whose target is missing or not a regular file.
+func processEntries(dir string, entries []os.DirEntry, ext string, action entryAction) error {
 	for _, e := range entries {
-		if filepath.Ext(e.Name()) == ext {
-			name := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		if isWorkFile(e.Name()) || filepath.Ext(e.Name()) != ext {
+			continue
+		}
 
-			err := action(name)
-			if err != nil {
-				return fmt.Errorf("failed to process entry %s: %w", name, err)
-			}
+		info, err := entryInfo(dir, e)
+		if err != nil {
+			return err
+		}
+
+		if info == nil {
+			continue
+		}
+
+		name := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+
+		if err := action(name, info); err != nil {
+			return fmt.Errorf("failed to process entry %s: %w", name, err)
 		}
 	}
 
@@ -194,194 +752,1026 @@ func processEntries(entries []os.DirEntry, ext string, action func(name string)
 
 // Storage represents Terraform state files storage.
 type Storage struct {
-	path string
+	path        string
+	resumable   bool           // Accept chunked uploads with Content-Range.
+	staleAfter  time.Duration  // Age after which a state is listed as stale, if set.
+	pattern     *regexp.Regexp // Pattern state names must match, if set.
+	maxLockBody int64          // Limit for LOCK and UNLOCK request bodies in bytes.
+	fallback    string         // Directory states missing from the storage are read from, if set.
+	populate    bool           // Copy states read from the fallback into the storage.
+	noFsync     bool           // Skip fsync when writing states.
+
+	releaseOnDisconnect bool          // Release locks when the connection that acquired them closes.
+	compression         []string      // Content codings GET responses are compressed with, in preference order.
+	minFreeSpace        int64         // Free space in bytes writes must leave, if set.
+	defaultSort         string        // Order of the state listing unless requested otherwise.
+	shrinkThreshold     int64         // Percentage by which writes may shrink a state, if set.
+	lockMaxLifetime     time.Duration // Time after which a lock is force-released, if set.
+	defaultName         string        // State requests to / operate on instead of listing the states, if set.
+	maxStateSize        int64         // Limit for written states in bytes, if set.
+	allowEmpty          bool          // Accept empty and whitespace-only states.
+	signer              *signer       // Verifies signatures of mutating requests, if set.
+	safeMode            *safeMode     // Safe mode freezing the states, if set.
+	lockRetryAfter      time.Duration // Base Retry-After answering LOCK on locked states with 429, if set.
+	debug               bool          // Serves diagnostic endpoints such as GET /{name}/lock.
+	maxLocks            int64         // Maximum number of locked states, unlimited if not positive.
+	redactor            *nameRedactor // Masks state names in metric labels, if set.
+
+	diskFree func(path string) (uint64, error) // Retrieves the free space of the file system holding path.
+
+	metrics *Metrics
+
+	lockMu sync.Mutex // Serializes creating, counting and expiring locks.
+
+	mu        sync.Mutex          // Guards uploads, rejected and lockConns.
+	uploads   map[string]*upload  // Resumable uploads in progress by state name.
+	rejected  map[string]struct{} // Unknown methods seen so far.
+	lockConns map[string]net.Conn // Connections holding locks by state name.
 }
 
-// isLocked returns true if lock file exists for given name.
-func (s *Storage) isLocked(name string) bool {
-	info, err := os.Stat(filepath.Join(s.path, name+lockFileExt))
-	if err != nil || info.IsDir() {
-		return false
+// reservedNames returns the first path segments of the server endpoints.
+// States with these names would be shadowed by the endpoints, e.g. GET /metrics never reads a state.
+func reservedNames() []string {
+	return []string{"metrics", "readyz", "stats", "import", "admin", strings.Split(discoveryPath, "/")[1]}
+}
+
+// validateName returns an error if name can't be used as a state name.
+// Names must stay within the storage directory, not be reserved and match the configured pattern.
+func (s *Storage) validateName(name string) error {
+	if name == "." || strings.ContainsAny(name, `/\`) || !filepath.IsLocal(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
 	}
 
-	return true
-}
+	if slices.Contains(reservedNames(), name) {
+		return fmt.Errorf("%w: %w: %q", ErrInvalidName, ErrReservedName, name)
+	}
 
-func (s *Storage) exists(name string) bool {
-	info, err := os.Stat(filepath.Join(s.path, name+stateFileExt))
-	if err != nil || info.IsDir() {
-		return false
+	if s.pattern != nil && !s.pattern.MatchString(name) {
+		return fmt.Errorf("%w: %q does not match %s", ErrInvalidName, name, s.pattern)
 	}
 
-	return true
+	return nil
 }
 
-// allStates is an HTTP handler that lists all Terraform state files available in the storage.
-func (s *Storage) allStates(w http.ResponseWriter, _ *http.Request) {
-	dir, err := os.Open(s.path)
+// syncFile flushes the temporary file to disk and closes it.
+// Flushing is skipped if fsync is disabled.
+func (s *Storage) syncFile(f *os.File) error {
+	err := f.Chmod(defaultFileMode)
+	if err == nil && !s.noFsync {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
 	if err != nil {
-		log.Error("failed to open directory:", "path", s.path, "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return fmt.Errorf("failed to sync %s: %w", f.Name(), err)
+	}
 
-		return
+	return nil
+}
+
+// commitFile flushes the temporary file to disk and atomically moves it to path.
+// The temporary file is removed if it can't be committed.
+func (s *Storage) commitFile(f *os.File, path string) error {
+	err := s.syncFile(f)
+	if err == nil {
+		err = os.Rename(f.Name(), path)
 	}
 
-	entries, err := dir.ReadDir(0)
 	if err != nil {
-		log.Error("failed to read directory:", "path", s.path, "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		os.Remove(f.Name())
 
-		return
+		return fmt.Errorf("failed to commit %s: %w", path, err)
 	}
 
-	var states States
+	return nil
+}
+
+// tempFile retrieves a temporary file holding data, created next to path.
+func tempFile(path string, data []byte) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*"+tmpFileExt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file for %s: %w", path, err)
+	}
 
-	if err := processEntries(entries, stateFileExt, states.Add); err != nil {
-		log.Error("failed to create states list:", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
 
-		return
+		return nil, fmt.Errorf("failed to write temporary file for %s: %w", path, err)
 	}
 
-	if err := processEntries(entries, lockFileExt, states.Lock); err != nil {
-		log.Error("failed to update locks for states in list:", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	return f, nil
+}
 
+// discardTemp removes the temporary file unless it was committed.
+// It is meant to be deferred, so the file is removed even if the handler is aborted midway.
+func discardTemp(f *os.File, committed *bool) {
+	if *committed {
 		return
 	}
 
-	type Result struct {
-		Status string  `json:"status"`
-		States *States `json:"states"`
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// writeFile atomically replaces the file at path with data,
+// so readers never observe a partially written file.
+func (s *Storage) writeFile(path string, data []byte) error {
+	f, err := tempFile(path, data)
+	if err != nil {
+		return err
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	committed := false
+	defer discardTemp(f, &committed)
 
-	if err := json.NewEncoder(w).Encode(Result{Status: "ok", States: &states}); err != nil {
-		log.Error("failed to encode JSON:", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if err := s.commitFile(f, path); err != nil {
+		return err
 	}
-}
 
-// handleState is a root handler for states.
-func (s *Storage) handleState(w http.ResponseWriter, r *http.Request) {
-	name := r.PathValue("name")
-	if name == "" {
-		http.Error(w, "Bad Request: missing name", http.StatusBadRequest)
+	committed = true
 
-		return
+	return nil
+}
+
+// createFile atomically creates the file at path with data.
+// Returns ErrAlreadyExists if the file exists.
+func (s *Storage) createFile(path string, data []byte) error {
+	f, err := tempFile(path, data)
+	if err != nil {
+		return err
 	}
 
-	log.Debug("Request", "method", r.Method, "name", name)
+	// The temporary file is only linked to path, so it is discarded in any case.
+	defer discardTemp(f, new(bool))
 
-	handler := map[string]func(http.ResponseWriter, *http.Request, string){
-		http.MethodGet:    s.handleGet,
-		http.MethodPost:   s.handlePost,
-		http.MethodDelete: s.handleDelete,
-		"LOCK":            s.handleLock,
-		"UNLOCK":          s.handleUnlock,
-	}[r.Method]
+	// Unlike os.Rename, os.Link refuses to replace an existing path.
+	err = s.syncFile(f)
+	if err == nil {
+		err = os.Link(f.Name(), path)
+	}
 
-	if handler == nil {
-		log.Warn("unknown method", "method", r.Method, "name", name)
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, path)
+	}
 
-		return
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
 	}
 
-	handler(w, r, name)
+	return nil
 }
 
-// handleGet is HTTP handler for GET method.
-func (s *Storage) handleGet(w http.ResponseWriter, _ *http.Request, name string) {
-	filePath := filepath.Join(s.path, name+stateFileExt)
+// unexpectedFileType returns an error describing a non-regular file found where a state or lock file is expected.
+func unexpectedFileType(path string, mode fs.FileMode) error {
+	kind := "irregular file"
+
+	switch {
+	case mode.IsDir():
+		kind = "directory"
+	case mode&fs.ModeNamedPipe != 0:
+		kind = "named pipe"
+	case mode&fs.ModeSocket != 0:
+		kind = "socket"
+	case mode&fs.ModeDevice != 0:
+		kind = "device"
+	}
+
+	return fmt.Errorf("%w: %s %s where a regular file is expected", ErrInconsistent, kind, path)
+}
 
-	data, err := os.ReadFile(filePath)
+// fileExists returns true if regular file exists at given path.
+// Returns an error if a directory or other non-regular file takes the place of the file.
+func fileExists(path string) (bool, error) {
+	info, err := os.Stat(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			http.Error(w, "Not Found", http.StatusNotFound)
-
-			return
+			return false, nil
 		}
 
-		log.Error("failed to read file", "name", name, "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
 
-		return
+	if !info.Mode().IsRegular() {
+		return false, unexpectedFileType(path, info.Mode())
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	return true, nil
+}
 
-	if _, err := w.Write(data); err != nil {
-		log.Error("failed to write response", "name", name, "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-	}
+// isLocked returns true if lock file exists for given name.
+func (s *Storage) isLocked(name string) (bool, error) {
+	return fileExists(filepath.Join(s.path, name+lockFileExt))
 }
 
-// handlePost if HTTP handler for POST method.
-func (s *Storage) handlePost(w http.ResponseWriter, r *http.Request, name string) {
-	defer r.Body.Close()
+// exists returns true if state file exists for given name.
+func (s *Storage) exists(name string) (bool, error) {
+	return fileExists(filepath.Join(s.path, name+stateFileExt))
+}
 
-	data, err := io.ReadAll(r.Body)
+// listStates retrieves all states available in the storage.
+func (s *Storage) listStates() (States, error) {
+	entries, err := os.ReadDir(s.path)
 	if err != nil {
-		log.Error("failed to read request body", "name", name, "error", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
-
-		return
+		return nil, fmt.Errorf("failed to read directory %s: %w", s.path, err)
 	}
 
-	filePath := filepath.Join(s.path, name+stateFileExt)
+	states := States{}
 
-	if !s.exists(name) {
-		w.WriteHeader(http.StatusCreated)
+	addState := func(name string, info fs.FileInfo) error {
+		return states.Add(name, info.ModTime())
 	}
 
-	if err := os.WriteFile(filePath, data, defaultFileMode); err != nil {
-		log.Error("failed to write file", "name", name, "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if err := processEntries(s.path, entries, stateFileExt, addState); err != nil {
+		return nil, fmt.Errorf("failed to create states list: %w", err)
 	}
-}
 
-// handleDelete is HTTP handler for DELETE method.
-func (s *Storage) handleDelete(w http.ResponseWriter, _ *http.Request, name string) {
-	filePath := filepath.Join(s.path, name+stateFileExt)
+	lockState := func(name string, _ fs.FileInfo) error {
+		return states.Lock(name)
+	}
 
-	if err := os.Remove(filePath); err != nil {
-		log.Error("failed to delete file", "name", name, "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if err := processEntries(s.path, entries, lockFileExt, lockState); err != nil {
+		return nil, fmt.Errorf("failed to update locks for states in list: %w", err)
 	}
-}
 
-// handleLock is HTTP handler for LOCK method.
-func (s *Storage) handleLock(w http.ResponseWriter, _ *http.Request, name string) {
-	if s.isLocked(name) {
-		log.Warn("state already locked", "name", name)
-		http.Error(w, "Locked", http.StatusLocked)
+	// Tags left behind by a state that no longer exists are ignored.
+	tagState := func(name string, _ fs.FileInfo) error {
+		state, ok := states.State(name)
+		if !ok {
+			return nil
+		}
 
-		return
+		tags, err := s.readTags(name)
+		state.Tags = tags
+
+		return err
 	}
 
-	lockFile := filepath.Join(s.path, name+lockFileExt)
-	if _, err := os.Create(lockFile); err != nil {
-		log.Error("failed to create lock file", "name", name, "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if err := processEntries(s.path, entries, tagsFileExt, tagState); err != nil {
+		return nil, fmt.Errorf("failed to add tags to states in list: %w", err)
 	}
+
+	return states, nil
 }
 
-// handleUnlock is HTTP handler for UNLOCK method.
-func (s *Storage) handleUnlock(w http.ResponseWriter, _ *http.Request, name string) {
-	if !s.isLocked(name) {
-		log.Warn("state not locked", "name", name)
-		http.Error(w, "Conflict", http.StatusConflict)
+const totalStatesHeader = "X-Total-States" // Header carrying the number of states of the listing.
+
+// handleRoot is a root handler for requests to /.
+// It lists the states, unless a default state is configured which requests then operate on.
+// The / pattern catches every path no other route matches, those are answered with 404
+// so that a mistyped URL never operates on the default state.
+func (s *Storage) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.Error(w, "Not Found", http.StatusNotFound)
+
+		return
+	}
+
+	if s.defaultName == "" {
+		s.allStates(w, r)
+
+		return
+	}
+
+	r.SetPathValue("name", s.defaultName)
+	s.handleState(w, r)
+}
+
+// allStates is an HTTP handler that lists all Terraform state files available in the storage.
+// States not updated within the stale-after duration are marked as stale.
+// An empty listing is answered with 204 No Content if requested with empty=204.
+// States are sorted in the order of the sort query parameter, or the configured default order.
+// Only states having all tags of the tag query parameters, given as key=value, are listed.
+// The listing carries an ETag, a request with a matching If-None-Match is answered with 304 Not Modified.
+// HEAD answers with the ETag and the number of states only, to cheaply detect changes of the set of states.
+func (s *Storage) allStates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if query.Has("empty") && query.Get("empty") != "204" {
+		http.Error(w, "Bad Request: invalid empty", http.StatusBadRequest)
+
+		return
+	}
+
+	staleAfter, ok := s.staleWindow(w, r)
+	if !ok {
+		return
+	}
+
+	states, err := s.listStates()
+	if err != nil {
+		log.Error("failed to list states:", "path", s.path, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+
+		return
+	}
+
+	states, err = filterTags(states, query["tag"])
+	if err != nil {
+		http.Error(w, "Bad Request: invalid tag", http.StatusBadRequest)
+
+		return
+	}
+
+	w.Header().Set(totalStatesHeader, strconv.Itoa(len(states)))
+
+	if len(states) == 0 && query.Has("empty") {
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+
+	if staleAfter > 0 {
+		states.MarkStale(time.Now().Add(-staleAfter))
+	}
+
+	order := s.defaultSort
+	if query.Has("sort") {
+		order = query.Get("sort")
+	}
+
+	if err := states.Sort(order); err != nil {
+		http.Error(w, "Bad Request: invalid sort", http.StatusBadRequest)
+
+		return
+	}
+
+	type Result struct {
+		Status string  `json:"status"`
+		States *States `json:"states"`
+	}
+
+	var buf bytes.Buffer
+
+	if err := json.NewEncoder(&buf).Encode(Result{Status: "ok", States: &states}); err != nil {
+		log.Error("failed to encode JSON:", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+
+		return
+	}
+
+	// The listing holds names, modification times and lock states, so its hash changes with any of them.
+	tag := etag(buf.Bytes())
+	w.Header().Set("ETag", tag)
+
+	if matchETagWeak(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+
+		return
+	}
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Error("failed to write response", "error", err)
+	}
+}
+
+// pathName retrieves the validated state name from the request path.
+// It responds with 400 and returns false if the name is missing or invalid.
+func (s *Storage) pathName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := r.PathValue("name")
+	if name == "" {
+		http.Error(w, "Bad Request: missing name", http.StatusBadRequest)
+
+		return "", false
+	}
+
+	if err := s.validateName(name); err != nil {
+		log.Warn("invalid name", "method", r.Method, "error", err)
+		http.Error(w, "Bad Request: "+nameErrorText("name", err), http.StatusBadRequest)
+
+		return "", false
+	}
+
+	return name, true
+}
+
+// nameErrorText returns the message answering a request whose state name in field is invalid.
+func nameErrorText(field string, err error) string {
+	if errors.Is(err, ErrReservedName) {
+		return field + " reserved for a server endpoint"
+	}
+
+	return "invalid " + field
+}
+
+// stateHandler is an HTTP handler for a request on the named state.
+type stateHandler func(w http.ResponseWriter, r *http.Request, name string)
+
+// stateHandlers retrieves the handlers of state requests by method.
+func (s *Storage) stateHandlers() map[string]stateHandler {
+	return map[string]stateHandler{
+		http.MethodGet:    s.handleGet,
+		http.MethodPost:   s.handlePost,
+		http.MethodDelete: s.handleDelete,
+		"LOCK":            s.handleLock,
+		"UNLOCK":          s.handleUnlock,
+	}
+}
+
+// handleState is a root handler for states.
+func (s *Storage) handleState(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.pathName(w, r)
+	if !ok {
+		return
+	}
+
+	log.Debug("Request", "method", r.Method, "name", name)
+
+	handler := s.stateHandlers()[r.Method]
+
+	if handler == nil {
+		s.rejectMethod(r.Method, name)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if err := s.expireLock(name); err != nil {
+		writeError(w, "failed to expire lock", name, err)
+
+		return
+	}
+
+	// The lock and size of a POST are checked before any body is read, the signature check included:
+	// clients sending Expect: 100-continue don't upload a state that is rejected anyway.
+	// A DELETE removes the lock along with the state, so it needs the lock ID as well.
+	if (r.Method == http.MethodPost || r.Method == http.MethodDelete) && !s.checkWrite(w, r, name) {
+		return
+	}
+
+	if !s.checkSignature(w, r, name) {
+		return
+	}
+
+	handler(w, r, name)
+}
+
+// etag returns the strong entity tag of the state content.
+func etag(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// matchETag reports whether the If-Match header value matches the entity tag.
+// Weak entity tags never match as If-Match requires the strong comparison.
+func matchETag(header, tag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		if v = strings.TrimSpace(v); v == "*" || v == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchETagWeak reports whether the If-None-Match header value matches the entity tag.
+// If-None-Match uses the weak comparison, so weak entity tags match as well.
+func matchETagWeak(header, tag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		if v = strings.TrimPrefix(strings.TrimSpace(v), "W/"); v == "*" || v == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkIfMatch reports whether the If-Match precondition of the request holds for the state.
+// A request without If-Match always satisfies it, a missing state never does.
+// The tags of the compressed representations GET returns match as well.
+func (s *Storage) checkIfMatch(r *http.Request, name string) (bool, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.path, name+stateFileExt))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("failed to read state %s: %w", name, err)
+	}
+
+	// Tags of compressed representations refer to the same state.
+	for _, encoding := range []string{"", encodingGzip, encodingDeflate} {
+		if matchETag(header, encodedETag(etag(data), encoding)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// rejectMethod counts a request with unknown method.
+// Only the first occurrence of each method is logged as a warning.
+func (s *Storage) rejectMethod(method, name string) {
+	s.metrics.rejectedMethods.inc(methodLabel(method))
+
+	s.mu.Lock()
+
+	_, seen := s.rejected[method]
+	if !seen && len(s.rejected) < maxLoggedMethods {
+		s.rejected[method] = struct{}{}
+	}
+
+	s.mu.Unlock()
+
+	if seen {
+		log.Debug("unknown method", "method", method, "name", name)
+
+		return
+	}
+
+	log.Warn("unknown method", "method", method, "name", name)
+}
+
+// handleGet is HTTP handler for GET method.
+// With the query parameter only the part of the state the JSON pointer refers to is returned.
+func (s *Storage) handleGet(w http.ResponseWriter, r *http.Request, name string) {
+	if _, err := s.exists(name); err != nil {
+		writeError(w, "failed to check state", name, err)
+
+		return
+	}
+
+	data, err := s.readState(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+
+			return
+		}
+
+		writeError(w, "failed to read file", name, err)
+
+		return
+	}
+
+	if query := r.URL.Query(); query.Has("query") {
+		writeQuery(w, data, query.Get("query"), name)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(data))
+
+	if err := s.writeCompressed(w, r, data); err != nil {
+		log.Error("failed to write response", "name", name, "error", err)
+	}
+}
+
+// checkEmpty returns ErrEmptyState or ErrBlankState if data is empty or whitespace-only,
+// unless empty states are allowed. Terraform can't parse either, they are mostly truncated uploads.
+func (s *Storage) checkEmpty(data []byte) error {
+	switch {
+	case s.allowEmpty:
+		return nil
+	case len(data) == 0:
+		return ErrEmptyState
+	case len(bytes.TrimSpace(data)) == 0:
+		return fmt.Errorf("%w: %d bytes", ErrBlankState, len(data))
+	default:
+		return nil
+	}
+}
+
+// checkState responds with 400 if data is an empty state, and with the status of the error
+// if it shrinks the current state too much or doesn't fit on the disk.
+// Every way of writing a state runs it on the complete state before writing it.
+// Returns true if the state may be written.
+func (s *Storage) checkState(w http.ResponseWriter, r *http.Request, name string, data []byte) bool {
+	if err := s.checkEmpty(data); err != nil {
+		log.Warn("empty state rejected", "name", name, "error", err)
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+
+		return false
+	}
+
+	if err := s.checkShrink(r, name, data); err != nil {
+		writeError(w, "state shrink rejected", name, err)
+
+		return false
+	}
+
+	if err := s.checkFreeSpace(len(data)); err != nil {
+		writeError(w, "not enough free space", name, err)
+
+		return false
+	}
+
+	return true
+}
+
+// checkWrite responds with 423 if the state is locked with another ID than the one of the POST or DELETE,
+// and with 413 if the announced body exceeds the maximum state size.
+// It only looks at the request headers, so a rejected state is never read.
+// Returns true if the request may proceed.
+func (s *Storage) checkWrite(w http.ResponseWriter, r *http.Request, name string) bool {
+	id := r.URL.Query().Get("ID")
+	if id == "" {
+		id = r.Header.Get(lockIDHeader)
+	}
+
+	match, err := s.checkLockID(name, id)
+	if err != nil {
+		writeError(w, "failed to check lock ID", name, err)
+
+		return false
+	}
+
+	if !match {
+		log.Warn("write with wrong lock ID", "name", name)
+		http.Error(w, "Locked", http.StatusLocked)
+
+		return false
+	}
+
+	if s.maxStateSize > 0 && r.ContentLength > s.maxStateSize {
+		log.Warn("state too large", "name", name, "size", r.ContentLength, "limit", s.maxStateSize)
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+
+		return false
+	}
+
+	return true
+}
+
+// handlePost if HTTP handler for POST method.
+// A locked state is only written with the ID it was locked with, sent as the ID query parameter
+// as Terraform does or in the X-Terraform-Lock-ID header. handleState checks it before the body is read,
+// see checkWrite.
+func (s *Storage) handlePost(w http.ResponseWriter, r *http.Request, name string) {
+	defer r.Body.Close()
+
+	if s.resumable && r.Header.Get("Content-Range") != "" {
+		s.handleChunk(w, r, name)
+
+		return
+	}
+
+	body := r.Body
+	if s.maxStateSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.maxStateSize)
+	}
+
+	data, err := io.ReadAll(body)
+	if maxErr := new(http.MaxBytesError); errors.As(err, &maxErr) {
+		log.Warn("state too large", "name", name, "limit", maxErr.Limit)
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		s.metrics.writeTimeouts.inc()
+		log.Warn("client too slow to send state", "name", name, "remote", r.RemoteAddr, "error", err)
+		http.Error(w, "Request Timeout", http.StatusRequestTimeout)
+
+		return
+	}
+
+	if err != nil {
+		log.Error("failed to read request body", "name", name, "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+
+		return
+	}
+
+	filePath := filepath.Join(s.path, name+stateFileExt)
+
+	exists, err := s.exists(name)
+	if err != nil {
+		writeError(w, "failed to check state", name, err)
+
+		return
+	}
+
+	if !s.checkState(w, r, name, data) {
+		return
+	}
+
+	if err := s.writeFile(filePath, data); err != nil {
+		writeError(w, "failed to write file", name, err)
+
+		return
+	}
+
+	s.metrics.stateWrites.inc(s.redactor.redact(name))
+
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// handleDelete is HTTP handler for DELETE method.
+// A locked state is only deleted with the ID it was locked with, like POST: handleState checks it, see checkWrite.
+func (s *Storage) handleDelete(w http.ResponseWriter, r *http.Request, name string) {
+	filePath := filepath.Join(s.path, name+stateFileExt)
+
+	if _, err := s.exists(name); err != nil {
+		writeError(w, "failed to check state", name, err)
+
+		return
+	}
+
+	match, err := s.checkIfMatch(r, name)
+	if err != nil {
+		writeError(w, "failed to check precondition", name, err)
+
+		return
+	}
+
+	if !match {
+		log.Warn("state changed since it was read", "name", name)
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+
+		return
+	}
+
+	if err := s.buryState(name); err != nil {
+		writeError(w, "failed to record deletion", name, err)
+
+		return
+	}
+
+	// A state only found in the fallback storage is deleted by the record alone.
+	err = os.Remove(filePath)
+	if errors.Is(err, os.ErrNotExist) && s.inFallback(name) {
+		err = nil
+	}
+
+	if err != nil {
+		writeError(w, "failed to delete file", name, err)
+
+		return
+	}
+
+	// The lock of a deleted state is meaningless and would be inherited by a state recreated under its name.
+	// The state is gone by now, so failing to remove its lock doesn't fail the DELETE.
+	err = os.Remove(filepath.Join(s.path, name+lockFileExt))
+	switch {
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		log.Warn("failed to delete lock file of deleted state", "name", name, "error", err)
+	case err == nil:
+		s.forgetLock(name)
+		log.Info("lock removed with deleted state", "name", name)
+	}
+
+	if err := os.Remove(filepath.Join(s.path, name+tagsFileExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn("failed to delete tags", "name", name, "error", err)
+	}
+}
+
+// readLockBody reads the lock info sent with LOCK and UNLOCK requests.
+// The body is limited to maxLockBody bytes, lock info is tiny and larger bodies are rejected.
+func (s *Storage) readLockBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxLockBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock info: %w", err)
+	}
+
+	return data, nil
+}
+
+// lockBodyError responds to a request whose lock info can't be read.
+func lockBodyError(w http.ResponseWriter, name string, err error) {
+	if maxErr := new(http.MaxBytesError); errors.As(err, &maxErr) {
+		log.Warn("lock info too large", "name", name, "limit", maxErr.Limit)
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	log.Error("failed to read request body", "name", name, "error", err)
+	http.Error(w, "Bad Request", http.StatusBadRequest)
+}
+
+// createLock atomically creates the lock file holding the lock info.
+// Returns ErrAlreadyLocked if the lock file already exists.
+func (s *Storage) createLock(name string, info []byte) error {
+	lockFile := filepath.Join(s.path, name+lockFileExt)
+
+	fh, err := os.OpenFile(lockFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, defaultFileMode)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return ErrAlreadyLocked
+		}
+
+		return fmt.Errorf("failed to create lock file %s: %w", lockFile, err)
+	}
+
+	_, err = fh.Write(info)
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(lockFile)
+
+		return fmt.Errorf("failed to write lock file %s: %w", lockFile, err)
+	}
+
+	return nil
+}
+
+// handleLock is HTTP handler for LOCK method.
+// The lock info sent by the client is kept in the lock file.
+func (s *Storage) handleLock(w http.ResponseWriter, r *http.Request, name string) {
+	locked, err := s.isLocked(name)
+	if err != nil {
+		writeError(w, "failed to check lock", name, err)
+
+		return
+	}
+
+	info, err := s.readLockBody(w, r)
+	if err != nil {
+		lockBodyError(w, name, err)
+
+		return
+	}
+
+	if !locked {
+		err = s.createLimitedLock(name, info)
+	}
+
+	if locked || errors.Is(err, ErrAlreadyLocked) {
+		s.relock(w, r, name, requestLockID(r, info))
+
+		return
+	}
+
+	if errors.Is(err, ErrTooManyLocks) {
+		writeError(w, "lock limit reached", name, err)
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, "failed to create lock file", name, err)
+
+		return
+	}
+
+	s.holdLock(r, name)
+}
+
+// relock answers a LOCK request for a locked state.
+// A retry by the client holding the lock, with the same ID, succeeds with the lock info,
+// as the first attempt may have locked the state without the client receiving the response.
+func (s *Storage) relock(w http.ResponseWriter, r *http.Request, name, id string) {
+	held, ok, err := s.heldLock(name, id)
+	if err != nil {
+		writeError(w, "failed to check lock", name, err)
+
+		return
+	}
+
+	if !ok {
+		s.lockContended(w, name)
+
+		return
+	}
+
+	log.Info("state already locked by the same ID", "name", name)
+	s.holdLock(r, name)
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := w.Write(held); err != nil {
+		log.Error("failed to write response", "name", name, "error", err)
+	}
+}
+
+// handleUnlock is HTTP handler for UNLOCK method.
+// The lock is only released for the ID it was acquired with, see checkLockID.
+func (s *Storage) handleUnlock(w http.ResponseWriter, r *http.Request, name string) {
+	locked, err := s.isLocked(name)
+	if err != nil {
+		writeError(w, "failed to check lock", name, err)
+
+		return
+	}
+
+	if !locked {
+		log.Warn("state not locked", "name", name)
+		http.Error(w, "Conflict", http.StatusConflict)
+
+		return
+	}
+
+	info, err := s.readLockBody(w, r)
+	if err != nil {
+		lockBodyError(w, name, err)
+
+		return
+	}
+
+	match, err := s.checkLockID(name, requestLockID(r, info))
+	if err != nil {
+		writeError(w, "failed to check lock ID", name, err)
+
+		return
+	}
+
+	if !match {
+		log.Warn("unlock with wrong lock ID", "name", name)
+		http.Error(w, "Conflict: lock ID mismatch", http.StatusConflict)
 
 		return
 	}
 
 	lockFile := filepath.Join(s.path, name+lockFileExt)
 	if err := os.Remove(lockFile); err != nil {
-		log.Error("failed to remove lock file", "name", name, "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeError(w, "failed to remove lock file", name, err)
+
+		return
+	}
+
+	s.forgetLock(name)
+}
+
+// seedState copies the state file into the storage unless the state already exists.
+// Returns true if the state was copied.
+func (s *Storage) seedState(name, file string) (bool, error) {
+	exists, err := s.exists(name)
+	if err != nil || exists {
+		return false, err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return false, fmt.Errorf("failed to read seed file %s: %w", file, err)
+	}
+
+	if err := s.writeFile(filepath.Join(s.path, name+stateFileExt), data); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Seed copies state files from dir into the storage.
+// States already present in the storage are skipped.
+func (s *Storage) Seed(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read seed directory %s: %w", dir, err)
+	}
+
+	var seeded, skipped int
+
+	for _, e := range entries {
+		if mode := e.Type() &^ fs.ModeSymlink; !mode.IsRegular() || filepath.Ext(e.Name()) != stateFileExt {
+			continue
+		}
+
+		name := strings.TrimSuffix(e.Name(), stateFileExt)
+		if err := s.validateName(name); err != nil {
+			log.Warn("seed file skipped", "file", e.Name(), "error", err)
+
+			skipped++
+
+			continue
+		}
+
+		ok, err := s.seedState(name, filepath.Join(dir, e.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to seed state %s: %w", name, err)
+		}
+
+		if !ok {
+			log.Info("seed skipped, state already exists", "name", name)
+
+			skipped++
+
+			continue
+		}
+
+		log.Info("state seeded", "name", name, "from", dir)
+
+		seeded++
 	}
+
+	log.Info("seeding completed", "seeded", seeded, "skipped", skipped)
+
+	return nil
 }
 
 func ensureDirectoryExists(path string) (os.FileInfo, error) {
@@ -420,31 +1810,153 @@ func NewStorage(path string) (*Storage, error) {
 		return nil, fmt.Errorf("%w: %s", ErrNotDirectory, path)
 	}
 
-	file := filepath.Join(path, testFileName)
+	s := &Storage{
+		path:        path,
+		metrics:     NewMetrics(),
+		maxLockBody: defaultMaxLockBody,
+		uploads:     make(map[string]*upload),
+		rejected:    make(map[string]struct{}),
+		lockConns:   make(map[string]net.Conn),
+		diskFree:    diskFree,
+		defaultSort: sortName,
+	}
 
-	fh, err := os.Create(file)
-	if err != nil {
-		return nil, fmt.Errorf("insufficient permissions for reading and writing in %s: %w", path, err)
+	s.metrics.freeSpace = &gaugeFunc{
+		name:  "terraform_backend_free_space_bytes",
+		help:  "Free space on the storage file system in bytes.",
+		value: s.freeSpaceBytes,
+	}
+	s.metrics.oldestState = &gaugeFunc{
+		name:  "terraform_backend_oldest_state_age_seconds",
+		help:  "Time since the least recently modified state was written in seconds.",
+		value: s.oldestStateAge,
 	}
 
-	if err := fh.Close(); err != nil {
-		return nil, fmt.Errorf("failed close testfile %s: %w", file, err)
+	if err := s.HealthCheck(context.Background()); err != nil {
+		return nil, err
 	}
 
-	if err := os.Remove(file); err != nil {
-		return nil, fmt.Errorf("failed remove testfile %s: %w", file, err)
+	return s, nil
+}
+
+// registerRoutes registers the storage handlers on mux.
+// A trailing slash after the state name is ignored, /{name}/ is served like /{name}.
+func (s *Storage) registerRoutes(mux handlerMux) {
+	mux.HandleFunc("/", s.handleRoot)
+	mux.HandleFunc("GET /metrics", s.metrics.handleMetrics)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	mux.HandleFunc("GET /stats", s.handleStats)
+	mux.HandleFunc("GET /stats/stale", s.handleStaleStats)
+	mux.HandleFunc("GET "+discoveryPath, s.handleDiscovery)
+	mux.HandleFunc("/{name}", s.handleState)
+	mux.HandleFunc("/{name}/{$}", s.handleState)
+	mux.HandleFunc("/{name}/{action}", s.handleAction)
+	mux.HandleFunc("POST /import/{name}", s.handleImport)
+}
+
+// compilePattern compiles the state name pattern.
+// It returns nil if the pattern is empty.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil //nolint:nilnil // no pattern means any name is allowed
 	}
 
-	s := &Storage{path: path}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile %q: %w", pattern, err)
+	}
 
-	return s, nil
+	return re, nil
 }
 
 func Run() int {
+	started := time.Now()
+	flags := parseFlags()
+	logLevel := new(log.LevelVar)
+
+	redactor, err := newNameRedactor(flags.redact)
+	if err != nil {
+		log.Error("invalid redact name pattern:", "error", err)
+
+		return 1
+	}
+
+	if err := setupLogging(flags.logFormat, flags.debug, logLevel, os.Stderr, redactor); err != nil {
+		log.Error("failed to setup logging:", "error", err)
+
+		return 1
+	}
+
+	notifyToggleDebug(logLevel, logLevel.Level())
+
 	log.Info("starting Terraform HTTP backend...")
 
-	flags := parseFlags()
-	setupLogging(flags.debug)
+	pattern, err := compilePattern(flags.pattern)
+	if err != nil {
+		log.Error("invalid name pattern:", "error", err)
+
+		return 1
+	}
+
+	compression, err := parseCompressionAlgos(flags.compress)
+	if err != nil {
+		log.Error("invalid compression algorithms:", "error", err)
+
+		return 1
+	}
+
+	if (flags.tlsCert == "") != (flags.tlsKey == "") {
+		log.Error("invalid TLS configuration: both certificate and key are required")
+
+		return 1
+	}
+
+	allowedHosts := parseHosts(flags.tlsHosts)
+
+	if len(allowedHosts) > 0 && flags.tlsCert == "" {
+		log.Error("invalid TLS configuration: allowed hosts require a certificate")
+
+		return 1
+	}
+
+	headers, err := parseResponseHeaders(flags.headers)
+	if err != nil {
+		log.Error("invalid response headers:", "error", err)
+
+		return 1
+	}
+
+	safe := newSafeMode(flags.safeMode, flags.admin)
+	notifyToggleSafeMode(safe)
+
+	root := safe.guard(http.DefaultServeMux)
+
+	if len(allowedHosts) > 0 {
+		root = requireHost(allowedHosts, root)
+	}
+
+	if len(headers) > 0 {
+		root = addResponseHeaders(headers, root)
+	}
+
+	handler, err := accessLog(flags.accessLog, os.Stdout, redactor, root)
+	if err != nil {
+		log.Error("invalid access log format:", "error", err)
+
+		return 1
+	}
+
+	if flags.shrink < 0 || flags.shrink > maxShrinkThreshold {
+		log.Error("invalid shrink threshold: must be a percentage", "threshold", flags.shrink)
+
+		return 1
+	}
+
+	if err := new(States).Sort(flags.sort); err != nil {
+		log.Error("invalid default sort:", "error", err)
+
+		return 1
+	}
 
 	storage, err := NewStorage(flags.path)
 	if err != nil {
@@ -453,8 +1965,78 @@ func Run() int {
 		return 1
 	}
 
-	http.HandleFunc("/", storage.allStates)
-	http.HandleFunc("/{name}", storage.handleState)
+	storage.resumable = flags.resumable
+	storage.pattern = pattern
+	storage.staleAfter = flags.stale
+	storage.maxLockBody = flags.lockBody
+	storage.fallback = flags.fallback
+	storage.populate = flags.populate
+	storage.noFsync = flags.noFsync
+	storage.releaseOnDisconnect = flags.release
+	storage.compression = compression
+	storage.minFreeSpace = flags.minFree
+	storage.defaultSort = flags.sort
+	storage.shrinkThreshold = flags.shrink
+	storage.lockMaxLifetime = flags.lockLife
+	storage.maxStateSize = flags.maxState
+	storage.allowEmpty = flags.allowZero
+	storage.lockRetryAfter = flags.lockRetry
+	storage.debug = flags.debug
+	storage.maxLocks = flags.maxLocks
+	storage.redactor = redactor
+	storage.safeMode = safe
+	storage.metrics.stateWrites.limit = int(flags.mStates)
+
+	if flags.signing != "" {
+		storage.signer = newSigner(flags.signing)
+	}
+
+	if flags.defName != "" {
+		if err := storage.validateName(flags.defName); err != nil {
+			log.Error("invalid default name:", "error", err)
+
+			return 1
+		}
+
+		storage.defaultName = flags.defName
+	}
+
+	if storage.noFsync {
+		log.Warn("fsync disabled, recently written states may be lost on power failure")
+	}
+
+	if flags.seedDir != "" {
+		if err := storage.Seed(flags.seedDir); err != nil {
+			log.Error("failed to seed storage:", "error", err)
+
+			return 1
+		}
+	}
+
+	mux := &routeMux{ServeMux: http.DefaultServeMux}
+	storage.registerRoutes(mux)
+
+	if flags.admin != "" {
+		mux.handleAdmin("GET /admin/runtime", flags.admin, handleRuntime(started))
+		mux.handleAdmin("POST /admin/locks/purge", flags.admin, storage.handlePurgeLocks)
+		mux.handleAdmin("POST /admin/safe-mode", flags.admin, safe.handleSafeMode)
+
+		if flags.unlockAll != "" {
+			mux.handleAdmin("POST /admin/unlock-all", flags.admin, storage.handleUnlockAll(flags.unlockAll))
+		}
+	}
+
+	routes := mux.effectiveRoutes(storage)
+
+	if flags.routes {
+		if err := printRoutes(os.Stdout, routes); err != nil {
+			log.Error("failed to print routes:", "error", err)
+		}
+	}
+
+	for _, r := range routes {
+		log.Debug("route", "pattern", r.pattern, "handler", r.handler)
+	}
 
 	log.Debug("bind address: " + flags.addr)
 
@@ -464,10 +2046,62 @@ func Run() int {
 		WriteTimeout:      1 * time.Second,
 		IdleTimeout:       1 * time.Minute,
 		ReadHeaderTimeout: 1 * time.Second,
-		Handler:           nil,
+		Handler:           handler,
+	}
+
+	var connHooks []func(net.Conn, http.ConnState)
+
+	if flags.connsIP > 0 {
+		limiter := newConnLimiter(int(flags.connsIP))
+		storage.metrics.connsPerIP = limiter
+		connHooks = append(connHooks, limiter.connState)
+	}
+
+	if storage.releaseOnDisconnect {
+		srv.ConnContext = connContext
+		connHooks = append(connHooks, storage.connState)
+	}
+
+	if len(connHooks) > 0 {
+		srv.ConnState = func(c net.Conn, state http.ConnState) {
+			for _, hook := range connHooks {
+				hook(c, state)
+			}
+		}
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
+	if flags.statsd != "" {
+		exporter, err := newStatsdExporter(flags.statsd, storage.metrics)
+		if err != nil {
+			log.Error("failed to setup StatsD:", "error", err)
+
+			return 1
+		}
+
+		go exporter.run(statsdFlushInterval)
+	}
+
+	var lc net.ListenConfig
+
+	if flags.reusePort {
+		lc.Control = reusePort
+	}
+
+	ln, err := lc.Listen(context.Background(), "tcp", flags.addr)
+	if err != nil {
+		log.Error("failed to listen:", "address", flags.addr, "error", err)
+
+		return 1
+	}
+
+	if flags.tlsCert != "" {
+		srv.TLSConfig = tlsConfig(allowedHosts)
+		err = srv.ServeTLS(ln, flags.tlsCert, flags.tlsKey)
+	} else {
+		err = srv.Serve(ln)
+	}
+
+	if err != nil {
 		log.Error("error running HTTP server:", log.Any("error", err))
 
 		return 1
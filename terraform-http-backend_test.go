@@ -1,13 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
 	"testing"
+	"time"
 )
 
 const name = "test"
@@ -126,3 +134,974 @@ func TestStorageHandleLockUnlock(t *testing.T) {
 		t.Fatalf("unexpected status code for UNLOCK: got %d, want %d", resUnlock.StatusCode, http.StatusOK)
 	}
 }
+
+func TestNewLogHandler(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []string{logFormatJSON, logFormatLogfmt} {
+		if _, err := newLogHandler(format, new(slog.LevelVar), io.Discard); err != nil {
+			t.Fatalf("unexpected error for format %s: %v", format, err)
+		}
+	}
+
+	if _, err := newLogHandler("xml", new(slog.LevelVar), io.Discard); !errors.Is(err, ErrInvalidLogFormat) {
+		t.Fatalf("unexpected error for invalid format: got %v, want %v", err, ErrInvalidLogFormat)
+	}
+}
+
+func TestStorageDirectoryInPlaceOfFile(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		ext     string
+		method  string
+		handler func(*Storage, http.ResponseWriter, *http.Request, string)
+	}{
+		{stateFileExt, http.MethodGet, (*Storage).handleGet},
+		{stateFileExt, http.MethodPost, (*Storage).handlePost},
+		{stateFileExt, http.MethodDelete, (*Storage).handleDelete},
+		{lockFileExt, "LOCK", (*Storage).handleLock},
+		{lockFileExt, "UNLOCK", (*Storage).handleUnlock},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			t.Parallel()
+
+			storage := setupTestStorage(t)
+
+			if err := os.Mkdir(filepath.Join(storage.path, name+tc.ext), defaultDirMode); err != nil {
+				t.Fatalf("failed to create directory: %v", err)
+			}
+
+			req := httptest.NewRequest(tc.method, "/test", bytes.NewReader([]byte("content")))
+			w := httptest.NewRecorder()
+
+			tc.handler(storage, w, req, name)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			if res.StatusCode != http.StatusInternalServerError {
+				t.Fatalf("unexpected status code: got %d, want %d", res.StatusCode, http.StatusInternalServerError)
+			}
+
+			if _, err := os.Stat(filepath.Join(storage.path, name+tc.ext)); err != nil {
+				t.Fatalf("directory was removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestStorageAllStatesDirectoryInPlaceOfFile(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	if err := os.Mkdir(filepath.Join(storage.path, name+stateFileExt), defaultDirMode); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	storage.allStates(w, req)
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("unexpected status code: got %d, want %d", res.StatusCode, http.StatusInternalServerError)
+	}
+}
+
+func TestToggleDebug(t *testing.T) {
+	t.Parallel()
+
+	lv := new(slog.LevelVar)
+	lv.Set(slog.LevelWarn)
+
+	if got := toggleDebug(lv, slog.LevelWarn); got != slog.LevelDebug || lv.Level() != slog.LevelDebug {
+		t.Fatalf("unexpected level after first toggle: got %s, want %s", got, slog.LevelDebug)
+	}
+
+	if got := toggleDebug(lv, slog.LevelWarn); got != slog.LevelWarn || lv.Level() != slog.LevelWarn {
+		t.Fatalf("unexpected level after second toggle: got %s, want %s", got, slog.LevelWarn)
+	}
+}
+
+func TestStorageValidateName(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	for _, name := range []string{
+		"", ".", "..", "../etc", "a/../../etc", `a\b`, "/etc/passwd", "metrics", "stats", "import", ".well-known",
+	} {
+		if err := storage.validateName(name); !errors.Is(err, ErrInvalidName) {
+			t.Fatalf("unexpected error for %q: got %v, want %v", name, err, ErrInvalidName)
+		}
+	}
+
+	storage.pattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+	for name, valid := range map[string]bool{"prod-network": true, "dev01": true, "Prod": false, "a_b": false} {
+		if err := storage.validateName(name); (err == nil) != valid {
+			t.Fatalf("unexpected result for %q: got error %v, want valid %v", name, err, valid)
+		}
+	}
+
+	// Names are single path segments, a pattern allowing '/' doesn't let one through.
+	storage.pattern = regexp.MustCompile(`^[a-z0-9-]+[/_][a-z0-9-]+$`)
+
+	for name, valid := range map[string]bool{"platform_prod": true, "platform/prod": false} {
+		if err := storage.validateName(name); (err == nil) != valid {
+			t.Fatalf("unexpected result for %q: got error %v, want valid %v", name, err, valid)
+		}
+	}
+}
+
+func TestStorageHandleStateInvalidName(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.pattern = regexp.MustCompile(`^[a-z]+$`)
+
+	for _, name := range []string{"../escape", "Test"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetPathValue("name", name)
+
+		w := httptest.NewRecorder()
+
+		storage.handleState(w, req)
+
+		res := w.Result()
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("unexpected status code for %q: got %d, want %d", name, res.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCompilePattern(t *testing.T) {
+	t.Parallel()
+
+	if re, err := compilePattern(""); re != nil || err != nil {
+		t.Fatalf("unexpected result for empty pattern: got %v, %v", re, err)
+	}
+
+	if _, err := compilePattern("^[a-z"); err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+}
+
+func TestStorageSeed(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	seedDir := t.TempDir()
+
+	for file, content := range map[string]string{
+		"new" + stateFileExt:      "seeded",
+		"existing" + stateFileExt: "seeded",
+		"ignored" + lockFileExt:   "",
+	} {
+		if err := os.WriteFile(filepath.Join(seedDir, file), []byte(content), defaultFileMode); err != nil {
+			t.Fatalf("failed to write seed file: %v", err)
+		}
+	}
+
+	existing := filepath.Join(storage.path, "existing"+stateFileExt)
+	if err := os.WriteFile(existing, []byte("current"), defaultFileMode); err != nil {
+		t.Fatalf("failed to write state file: %v", err)
+	}
+
+	if err := storage.Seed(seedDir); err != nil {
+		t.Fatalf("failed to seed storage: %v", err)
+	}
+
+	for file, want := range map[string]string{"new" + stateFileExt: "seeded", "existing" + stateFileExt: "current"} {
+		got, err := os.ReadFile(filepath.Join(storage.path, file))
+		if err != nil {
+			t.Fatalf("failed to read state file: %v", err)
+		}
+
+		if string(got) != want {
+			t.Fatalf("unexpected content of %s: got %q, want %q", file, got, want)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(storage.path, "ignored"+lockFileExt)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("lock file was seeded: %v", err)
+	}
+}
+
+func TestStorageHandleDeleteIfMatch(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	content := []byte("test content")
+	filePath := filepath.Join(storage.path, name+stateFileExt)
+
+	if err := os.WriteFile(filePath, content, defaultFileMode); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	for _, tc := range []struct {
+		ifMatch string
+		want    int
+	}{
+		{etag([]byte("other content")), http.StatusPreconditionFailed},
+		{"W/" + etag(content), http.StatusPreconditionFailed},
+		{etag(content), http.StatusOK},
+		{etag(content), http.StatusPreconditionFailed},
+	} {
+		req := httptest.NewRequest(http.MethodDelete, "/test", nil)
+		req.Header.Set("If-Match", tc.ifMatch)
+
+		w := httptest.NewRecorder()
+
+		storage.handleDelete(w, req, name)
+
+		if w.Code != tc.want {
+			t.Fatalf("unexpected status code for If-Match %s: got %d, want %d", tc.ifMatch, w.Code, tc.want)
+		}
+	}
+
+	if _, err := os.Stat(filePath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("state was not deleted: %v", err)
+	}
+}
+
+func TestStorageHandleDeleteRemovesLock(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	lockPath := filepath.Join(storage.path, name+lockFileExt)
+
+	statePath := filepath.Join(storage.path, name+stateFileExt)
+
+	writeTestFile(t, statePath, "content")
+	writeTestFile(t, lockPath, `{"ID":"held"}`)
+
+	del := func(target string) int {
+		req := httptest.NewRequest(http.MethodDelete, target, nil)
+		req.SetPathValue("name", name)
+
+		w := httptest.NewRecorder()
+		storage.handleState(w, req)
+
+		return w.Code
+	}
+
+	// Only the lock holder may delete the state, and its lock with it.
+	for _, target := range []string{"/" + name, "/" + name + "?ID=other"} {
+		if code := del(target); code != http.StatusLocked {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", target, code, http.StatusLocked)
+		}
+
+		if _, err := os.Stat(statePath); err != nil {
+			t.Fatalf("state deleted by %s: %v", target, err)
+		}
+
+		if _, err := os.Stat(lockPath); err != nil {
+			t.Fatalf("lock deleted by %s: %v", target, err)
+		}
+	}
+
+	if code := del("/" + name + "?ID=held"); code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", code, http.StatusOK)
+	}
+
+	if _, err := os.Stat(lockPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("lock was not deleted: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	storage.handleLock(w, httptest.NewRequest("LOCK", "/"+name, bytes.NewBufferString(`{"ID":"new"}`)), name)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code locking the recreated state: got %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestStorageHandleDeleteLockRemovalFails(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	statePath := filepath.Join(storage.path, name+stateFileExt)
+
+	writeTestFile(t, statePath, "content")
+	// A non-empty directory in place of the lock file can't be removed.
+	lockPath := filepath.Join(storage.path, name+lockFileExt)
+	if err := os.Mkdir(lockPath, defaultDirMode); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(lockPath, "entry"), "content")
+
+	w := httptest.NewRecorder()
+	storage.handleDelete(w, httptest.NewRequest(http.MethodDelete, "/"+name, nil), name)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if _, err := os.Stat(statePath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("state was not deleted: %v", err)
+	}
+}
+
+func listTestStates(t *testing.T, storage *Storage, target string) States {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+
+	storage.allStates(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	var result struct {
+		States States `json:"states"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	return result.States
+}
+
+func TestStorageAllStatesStale(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	old := time.Now().Add(-48 * time.Hour)
+
+	for _, state := range []string{"fresh", "stale"} {
+		filePath := filepath.Join(storage.path, state+stateFileExt)
+		if err := os.WriteFile(filePath, []byte("{}"), defaultFileMode); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+	}
+
+	if err := os.Chtimes(filepath.Join(storage.path, "stale"+stateFileExt), old, old); err != nil {
+		t.Fatalf("failed to change file times: %v", err)
+	}
+
+	for _, target := range []string{"/", "/?stale-after=24h"} {
+		states := listTestStates(t, storage, target)
+
+		for _, state := range states {
+			want := target != "/" && state.Name == "stale"
+			if state.Stale != want {
+				t.Fatalf("unexpected stale flag for %s at %s: got %v, want %v", state.Name, target, state.Stale, want)
+			}
+		}
+	}
+
+	storage.staleAfter = 24 * time.Hour
+
+	states := listTestStates(t, storage, "/")
+
+	if state, ok := states.State("stale"); !ok || !state.Stale {
+		t.Fatalf("state not marked stale by server default: %+v", state)
+	}
+}
+
+func TestStorageHandleLockBodyLimit(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.maxLockBody = 16
+
+	req := httptest.NewRequest("LOCK", "/test", bytes.NewReader(bytes.Repeat([]byte("x"), 17)))
+	w := httptest.NewRecorder()
+
+	storage.handleLock(w, req, name)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	if locked, _ := storage.isLocked(name); locked {
+		t.Fatal("state locked by oversized request")
+	}
+
+	info := []byte(`{"ID":"1"}`)
+	req = httptest.NewRequest("LOCK", "/test", bytes.NewReader(info))
+	w = httptest.NewRecorder()
+
+	storage.handleLock(w, req, name)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	stored, err := os.ReadFile(filepath.Join(storage.path, name+lockFileExt))
+	if err != nil {
+		t.Fatalf("failed to read lock file: %v", err)
+	}
+
+	// The lock info is stored as sent, stamped with the acquisition time.
+	li, err := parseLockInfo(stored)
+	if err != nil || li.ID != "1" || li.Acquired.IsZero() {
+		t.Fatalf("unexpected lock info: got %s, want %s with acquisition time", stored, info)
+	}
+}
+
+func TestStorageAllStatesEmpty(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	for target, want := range map[string]int{"/": http.StatusOK, "/?empty=204": http.StatusNoContent} {
+		w := httptest.NewRecorder()
+
+		storage.allStates(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+		if w.Code != want {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", target, w.Code, want)
+		}
+
+		if want == http.StatusOK && !bytes.Contains(w.Body.Bytes(), []byte(`"states":[]`)) {
+			t.Fatalf("unexpected response body for %s: %s", target, w.Body.String())
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(storage.path, name+stateFileExt), []byte("{}"), defaultFileMode); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	if states := listTestStates(t, storage, "/?empty=204"); len(states) != 1 {
+		t.Fatalf("unexpected states: %+v", states)
+	}
+}
+
+func BenchmarkStorageHandlePost(b *testing.B) {
+	content := bytes.Repeat([]byte(`{"resources":[]}`), 4096)
+
+	for _, noFsync := range []bool{false, true} {
+		b.Run(fmt.Sprintf("noFsync=%v", noFsync), func(b *testing.B) {
+			storage, err := NewStorage(b.TempDir())
+			if err != nil {
+				b.Fatalf("failed to initialize storage: %v", err)
+			}
+
+			storage.noFsync = noFsync
+
+			b.SetBytes(int64(len(content)))
+
+			for range b.N {
+				w := httptest.NewRecorder()
+
+				storage.handlePost(w, httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(content)), name)
+
+				if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+					b.Fatalf("unexpected status code: %d", w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestStorageAllStatesETag(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	filePath := filepath.Join(storage.path, name+stateFileExt)
+	lockPath := filepath.Join(storage.path, name+lockFileExt)
+
+	list := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+
+		w := httptest.NewRecorder()
+		storage.allStates(w, req)
+
+		return w
+	}
+
+	mutations := []struct {
+		name   string
+		mutate func() error
+	}{
+		{"create", func() error { return os.WriteFile(filePath, []byte("content"), defaultFileMode) }},
+		{"update", func() error {
+			updated := time.Now().Add(time.Hour)
+
+			return os.Chtimes(filePath, updated, updated)
+		}},
+		{"lock", func() error { return os.WriteFile(lockPath, nil, defaultFileMode) }},
+		{"unlock", func() error { return os.Remove(lockPath) }},
+		{"delete", func() error { return os.Remove(filePath) }},
+	}
+
+	tag := list("").Header().Get("ETag")
+
+	for _, m := range mutations {
+		if w := list(tag); w.Code != http.StatusNotModified {
+			t.Fatalf("unexpected status code before %s: got %d, want %d", m.name, w.Code, http.StatusNotModified)
+		}
+
+		if w := list("W/" + tag); w.Code != http.StatusNotModified {
+			t.Fatalf("unexpected status code for weak tag before %s: got %d, want %d",
+				m.name, w.Code, http.StatusNotModified)
+		}
+
+		if err := m.mutate(); err != nil {
+			t.Fatalf("failed to %s state: %v", m.name, err)
+		}
+
+		w := list(tag)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status code after %s: got %d, want %d", m.name, w.Code, http.StatusOK)
+		}
+
+		if w.Header().Get("ETag") == tag {
+			t.Fatalf("ETag not changed after %s: %s", m.name, tag)
+		}
+
+		tag = w.Header().Get("ETag")
+	}
+}
+
+func TestStorageAllStatesHead(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	for _, n := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(storage.path, n+stateFileExt), []byte("content"), defaultFileMode); err != nil {
+			t.Fatalf("failed to write state: %v", err)
+		}
+	}
+
+	get := httptest.NewRecorder()
+	storage.allStates(get, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	head := httptest.NewRecorder()
+	storage.allStates(head, httptest.NewRequest(http.MethodHead, "/", nil))
+
+	if head.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", head.Code, http.StatusOK)
+	}
+
+	if got, want := head.Header().Get("ETag"), get.Header().Get("ETag"); got == "" || got != want {
+		t.Fatalf("unexpected ETag: got %q, want %q", got, want)
+	}
+
+	if got := head.Header().Get(totalStatesHeader); got != "2" {
+		t.Fatalf("unexpected %s: got %q, want %q", totalStatesHeader, got, "2")
+	}
+
+	if head.Body.Len() != 0 {
+		t.Fatalf("unexpected body: %q", head.Body.String())
+	}
+}
+
+func TestStorageDefaultName(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	mux := http.NewServeMux()
+	storage.registerRoutes(mux)
+
+	list := httptest.NewRecorder()
+	mux.ServeHTTP(list, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !bytes.Contains(list.Body.Bytes(), []byte(`"states"`)) {
+		t.Fatalf("states not listed without default name: %q", list.Body.String())
+	}
+
+	storage.defaultName = name
+
+	testCases := []struct {
+		method string
+		body   string
+		want   int
+	}{
+		{http.MethodPost, "content", http.StatusCreated},
+		{http.MethodGet, "", http.StatusOK},
+		{"LOCK", "", http.StatusOK},
+		{"LOCK", "", http.StatusLocked},
+		{"UNLOCK", "", http.StatusOK},
+		{http.MethodDelete, "", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(tc.method, "/", bytes.NewBufferString(tc.body)))
+
+		if w.Code != tc.want {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", tc.method, w.Code, tc.want)
+		}
+
+		if tc.method == http.MethodGet && w.Body.String() != "content" {
+			t.Fatalf("unexpected response body: %q", w.Body.String())
+		}
+
+		if tc.method == http.MethodPost {
+			if _, err := os.Stat(filepath.Join(storage.path, name+stateFileExt)); err != nil {
+				t.Fatalf("default state not written under its name: %v", err)
+			}
+		}
+	}
+}
+
+func TestStorageReservedNames(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	mux := http.NewServeMux()
+	storage.registerRoutes(mux)
+
+	writeTestFile(t, filepath.Join(storage.path, name+stateFileExt), "content")
+
+	for _, target := range []string{"/metrics", "/stats/", "/" + name + "/copy?to=readyz"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, bytes.NewBufferString("content")))
+
+		if w.Code != http.StatusBadRequest || !bytes.Contains(w.Body.Bytes(), []byte("reserved")) {
+			t.Fatalf("unexpected response for %s: got %d %q", target, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestStorageDefaultNameUnmatchedPath(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.defaultName = name
+	mux := http.NewServeMux()
+	storage.registerRoutes(mux)
+
+	writeTestFile(t, filepath.Join(storage.path, name+stateFileExt), "content")
+
+	for _, method := range []string{http.MethodPost, http.MethodDelete, "LOCK", http.MethodGet} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, "/a/b/c", bytes.NewBufferString("other")))
+
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", method, w.Code, http.StatusNotFound)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(storage.path, name+stateFileExt))
+	if err != nil || string(data) != "content" {
+		t.Fatalf("default state changed: %q, %v", data, err)
+	}
+
+	if locked, _ := storage.isLocked(name); locked {
+		t.Fatal("default state locked through an unmatched path")
+	}
+}
+
+func TestStorageTrailingSlash(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	mux := http.NewServeMux()
+	storage.registerRoutes(mux)
+
+	testCases := []struct {
+		method string
+		body   string
+		want   int
+	}{
+		{http.MethodPost, "content", http.StatusCreated},
+		{http.MethodGet, "", http.StatusOK},
+		{"LOCK", "", http.StatusOK},
+		{"LOCK", "", http.StatusLocked},
+		{"UNLOCK", "", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(tc.method, "/"+name+"/", bytes.NewBufferString(tc.body)))
+
+		if w.Code != tc.want {
+			t.Fatalf("unexpected status code for %s: got %d, want %d", tc.method, w.Code, tc.want)
+		}
+
+		if tc.method == http.MethodGet && w.Body.String() != "content" {
+			t.Fatalf("unexpected response body: %q", w.Body.String())
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(storage.path, name+stateFileExt)); err != nil {
+		t.Fatalf("state not written under its name: %v", err)
+	}
+}
+
+func TestStorageAllStatesSort(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.defaultSort = sortUpdatedDesc
+	now := time.Now()
+
+	for i, state := range []string{"b", "c", "a"} {
+		filePath := filepath.Join(storage.path, state+stateFileExt)
+		updated := now.Add(time.Duration(i) * time.Hour)
+
+		if err := os.WriteFile(filePath, []byte("content"), defaultFileMode); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		if err := os.Chtimes(filePath, updated, updated); err != nil {
+			t.Fatalf("failed to change file times: %v", err)
+		}
+	}
+
+	for target, want := range map[string]string{
+		"/":                   "acb",
+		"/?sort=updated":      "bca",
+		"/?sort=name":         "abc",
+		"/?sort=name-desc":    "cba",
+		"/?sort=updated-desc": "acb",
+	} {
+		got := ""
+		for _, state := range listTestStates(t, storage, target) {
+			got += state.Name
+		}
+
+		if got != want {
+			t.Fatalf("unexpected order for %s: got %s, want %s", target, got, want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	storage.allStates(w, httptest.NewRequest(http.MethodGet, "/?sort=size", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code for invalid sort: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestStorageWorkFilesInvisible(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	f, err := tempFile(filepath.Join(storage.path, name+stateFileExt), []byte("content"))
+	if err != nil {
+		t.Fatalf("failed to create temporary file: %v", err)
+	}
+
+	defer f.Close()
+
+	part, err := os.CreateTemp(storage.path, "upload-*"+partFileExt)
+	if err != nil {
+		t.Fatalf("failed to create partial file: %v", err)
+	}
+
+	defer part.Close()
+
+	if states := listTestStates(t, storage, "/"); len(states) != 0 {
+		t.Fatalf("work files listed as states: %+v", states)
+	}
+
+	if exists, err := storage.exists(name); err != nil || exists {
+		t.Fatalf("work file taken for the state: exists %v, error %v", exists, err)
+	}
+
+	if !isWorkFile(filepath.Base(f.Name())) || !isWorkFile(filepath.Base(part.Name())) {
+		t.Fatalf("work files not recognized: %s, %s", f.Name(), part.Name())
+	}
+}
+
+// slowBody is a request body whose client stops sending after the first bytes until the read deadline.
+type slowBody struct {
+	sent bool
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	if !b.sent {
+		b.sent = true
+
+		return copy(p, "partial"), nil
+	}
+
+	return 0, &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+}
+
+func TestStorageHandlePostWriteTimeout(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	w := httptest.NewRecorder()
+	storage.handlePost(w, httptest.NewRequest(http.MethodPost, "/"+name, &slowBody{}), name)
+
+	if w.Code != http.StatusRequestTimeout {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusRequestTimeout)
+	}
+
+	if got := storage.metrics.writeTimeouts.value.Load(); got != 1 {
+		t.Fatalf("unexpected write timeout count: got %d, want 1", got)
+	}
+
+	// A write failing after the temporary file is created must not leave it behind either.
+	if err := os.Mkdir(filepath.Join(storage.path, "dir"+stateFileExt), defaultDirMode); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	if err := storage.writeFile(filepath.Join(storage.path, "dir"+stateFileExt), []byte("content")); err == nil {
+		t.Fatal("expected error replacing a directory")
+	}
+
+	entries, err := os.ReadDir(storage.path)
+	if err != nil {
+		t.Fatalf("failed to read storage: %v", err)
+	}
+
+	for _, e := range entries {
+		if isWorkFile(e.Name()) || e.Name() == name+stateFileExt {
+			t.Fatalf("file left behind: %s", e.Name())
+		}
+	}
+}
+
+func TestStorageHandlePostMaxStateSize(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+	storage.maxStateSize = 4
+
+	for body, want := range map[string]int{"12345": http.StatusRequestEntityTooLarge, "1234": http.StatusCreated} {
+		w := httptest.NewRecorder()
+		storage.handlePost(w, httptest.NewRequest(http.MethodPost, "/"+name, bytes.NewBufferString(body)), name)
+
+		if w.Code != want {
+			t.Fatalf("unexpected status code for %q: got %d, want %d", body, w.Code, want)
+		}
+	}
+}
+
+func TestStorageHandlePostEmpty(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		body       string
+		allowEmpty bool
+		want       int
+		wantBody   string
+	}{
+		{"empty", "", false, http.StatusBadRequest, "Bad Request: empty state\n"},
+		{"whitespace", " \n\t", false, http.StatusBadRequest, "Bad Request: whitespace-only state: 3 bytes\n"},
+		{"empty allowed", "", true, http.StatusCreated, ""},
+		{"whitespace allowed", " \n\t", true, http.StatusCreated, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			storage := setupTestStorage(t)
+			storage.allowEmpty = tc.allowEmpty
+
+			w := httptest.NewRecorder()
+			storage.handlePost(w, httptest.NewRequest(http.MethodPost, "/"+name, bytes.NewBufferString(tc.body)), name)
+
+			if w.Code != tc.want {
+				t.Fatalf("unexpected status code: got %d, want %d", w.Code, tc.want)
+			}
+
+			if w.Body.String() != tc.wantBody {
+				t.Fatalf("unexpected response body: got %q, want %q", w.Body.String(), tc.wantBody)
+			}
+
+			_, err := os.Stat(filepath.Join(storage.path, name+stateFileExt))
+			if written := err == nil; written != tc.allowEmpty {
+				t.Fatalf("unexpected state file: written %v, error %v", written, err)
+			}
+		})
+	}
+}
+
+func TestStorageExpectContinue(t *testing.T) {
+	t.Parallel()
+
+	const size = 1 << 20
+
+	testCases := []struct {
+		name    string
+		target  string
+		signed  bool
+		want    int
+		proceed bool
+	}{
+		{"accepted", "/new", false, http.StatusCreated, true},
+		{"locked", "/" + name + "?ID=other", false, http.StatusLocked, false},
+		{"too large", "/new", false, http.StatusRequestEntityTooLarge, false},
+		{"locked and signed", "/" + name + "?ID=other", true, http.StatusLocked, false},
+		{"unsigned", "/new", true, http.StatusUnauthorized, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			storage := setupTestStorage(t)
+			writeTestFile(t, filepath.Join(storage.path, name+lockFileExt), `{"ID":"held"}`)
+
+			if tc.want == http.StatusRequestEntityTooLarge {
+				storage.maxStateSize = size - 1
+			}
+
+			if tc.signed {
+				storage.signer = newSigner("secret")
+			}
+
+			mux := &routeMux{ServeMux: http.NewServeMux()}
+			storage.registerRoutes(mux)
+
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer conn.Close()
+
+			fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: test\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n",
+				tc.target, size)
+
+			br := bufio.NewReader(conn)
+
+			res, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+
+			if continued := res.StatusCode == http.StatusContinue; continued != tc.proceed {
+				t.Fatalf("unexpected interim response: got %d, continue %v", res.StatusCode, tc.proceed)
+			}
+
+			if tc.proceed {
+				if _, err := conn.Write(bytes.Repeat([]byte("a"), size)); err != nil {
+					t.Fatalf("failed to send body: %v", err)
+				}
+
+				if res, err = http.ReadResponse(br, nil); err != nil {
+					t.Fatalf("failed to read response: %v", err)
+				}
+			}
+
+			res.Body.Close()
+
+			if res.StatusCode != tc.want {
+				t.Fatalf("unexpected status code: got %d, want %d", res.StatusCode, tc.want)
+			}
+		})
+	}
+}
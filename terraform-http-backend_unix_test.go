@@ -0,0 +1,87 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestStorageFIFOInPlaceOfFile(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	if err := syscall.Mkfifo(filepath.Join(storage.path, name+stateFileExt), defaultFileMode); err != nil {
+		t.Fatalf("failed to create FIFO: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+
+	storage.handleGet(w, httptest.NewRequest(http.MethodGet, "/test", nil), name)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected status code for GET: got %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	w = httptest.NewRecorder()
+
+	storage.allStates(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected status code for listing: got %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestStorageAllStatesSymlinks(t *testing.T) {
+	t.Parallel()
+
+	storage := setupTestStorage(t)
+
+	writeTestFile(t, filepath.Join(storage.path, name+stateFileExt), `{"version":4}`)
+
+	target := t.TempDir()
+	writeTestFile(t, filepath.Join(target, "linked"+stateFileExt), `{"version":4}`)
+
+	links := map[string]string{
+		"linked":   filepath.Join(target, "linked"+stateFileExt),
+		"dangling": filepath.Join(target, "missing"+stateFileExt),
+		"dir":      target,
+	}
+
+	for state, dst := range links {
+		if err := os.Symlink(dst, filepath.Join(storage.path, state+stateFileExt)); err != nil {
+			t.Fatalf("failed to create symlink for %s: %v", state, err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+
+	storage.allStates(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var result struct {
+		States []State `json:"states"`
+	}
+
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode states: %v", err)
+	}
+
+	got := map[string]bool{}
+	for _, state := range result.States {
+		got[state.Name] = true
+	}
+
+	if len(got) != 2 || !got[name] || !got["linked"] {
+		t.Fatalf("unexpected states: got %v, want %s and linked", got, name)
+	}
+}
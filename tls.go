@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var ErrHostNotAllowed = errors.New("host not allowed")
+
+// parseHosts parses the comma separated list of allowed hostnames.
+// A hostname starting with *. allows any subdomain of the rest, e.g. *.example.com.
+func parseHosts(list string) []string {
+	var hosts []string
+
+	for _, h := range strings.Split(list, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+
+	return hosts
+}
+
+// hostAllowed reports whether the hostname, with or without port, is in the allowlist.
+// Every hostname is allowed if the allowlist is empty.
+func hostAllowed(allowed []string, host string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, a := range allowed {
+		if suffix, ok := strings.CutPrefix(a, "*"); ok && strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+			return true
+		}
+
+		if host == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requireHost wraps the handler to answer requests to hostnames not in the allowlist with 421.
+// Both the SNI of the connection and the Host header must be allowed, so neither can be used to reach
+// the backend under another name.
+func requireHost(allowed []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sni := ""
+		if r.TLS != nil {
+			sni = r.TLS.ServerName
+		}
+
+		if !hostAllowed(allowed, r.Host) || (sni != "" && !hostAllowed(allowed, sni)) {
+			log.Warn("request to host not allowed", "host", r.Host, "sni", sni, "remote", r.RemoteAddr)
+			http.Error(w, "Misdirected Request", http.StatusMisdirectedRequest)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tlsConfig retrieves the TLS configuration refusing handshakes for SNI hostnames not in the allowlist.
+// Clients sending no SNI, e.g. connecting by IP, are left to the Host check.
+func tlsConfig(allowed []string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if hello.ServerName != "" && !hostAllowed(allowed, hello.ServerName) {
+				return nil, fmt.Errorf("%w: %s", ErrHostNotAllowed, hello.ServerName)
+			}
+
+			return nil, nil //nolint:nilnil // nil keeps the server configuration
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHostAllowed(t *testing.T) {
+	t.Parallel()
+
+	allowed := parseHosts(" TF.example.com, *.ci.example.com ,")
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"tf.example.com", true},
+		{"TF.Example.com:443", true},
+		{"tf.example.com.", true},
+		{"runner.ci.example.com", true},
+		{"ci.example.com", false},
+		{"other.example.com", false},
+		{"tf.example.com.evil.test", false},
+		{"10.0.0.1:443", false},
+	}
+
+	for _, tt := range tests {
+		if got := hostAllowed(allowed, tt.host); got != tt.want {
+			t.Errorf("hostAllowed(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+
+	if !hostAllowed(nil, "any.test") {
+		t.Error("host rejected without allowlist")
+	}
+}
+
+func TestRequireHost(t *testing.T) {
+	t.Parallel()
+
+	handler := requireHost([]string{"tf.example.com"}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	tests := []struct {
+		host string
+		sni  string
+		want int
+	}{
+		{"tf.example.com", "tf.example.com", http.StatusOK},
+		{"tf.example.com", "", http.StatusOK},
+		{"other.example.com", "tf.example.com", http.StatusMisdirectedRequest},
+		{"tf.example.com", "other.example.com", http.StatusMisdirectedRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "https://"+tt.host+"/", nil)
+		req.TLS.ServerName = tt.sni
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("unexpected status code for host %q, SNI %q: got %d, want %d", tt.host, tt.sni, w.Code, tt.want)
+		}
+	}
+}
+
+func TestTLSConfigSNI(t *testing.T) {
+	t.Parallel()
+
+	cfg := tlsConfig([]string{"tf.example.com"})
+
+	for sni, allowed := range map[string]bool{"tf.example.com": true, "": true, "other.example.com": false} {
+		_, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{ServerName: sni})
+		if got := !errors.Is(err, ErrHostNotAllowed); got != allowed {
+			t.Errorf("unexpected handshake result for SNI %q: error %v", sni, err)
+		}
+	}
+}